@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+)
+
+// handleAdminInfo is a small status endpoint for operators.
+func (s *Server) handleAdminInfo(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"users":      s.userCount(),
+		"legacyHits": s.legacyHitCounts(),
+	})
+}
+
+// loadAdminClientCAs reads the operator-configured CA bundle for admin
+// mTLS, if one is set. Returning nil means client certs arent required.
+func loadAdminClientCAs() (*x509.CertPool, error) {
+	path := os.Getenv("ATP_ADMIN_CLIENT_CA")
+	if path == "" {
+		return nil, nil
+	}
+
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, os.ErrInvalid
+	}
+	return pool, nil
+}
+
+// requireAdminClientCert gates the /admin group behind mTLS when a CA
+// pool is configured. The TLS listener must be running with
+// VerifyClientCertIfGiven for the peer certs to show up here, plaintext
+// dev mode with no pool configured is unaffected.
+func (s *Server) requireAdminClientCert(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.AdminClientCAs == nil {
+			return next(c)
+		}
+
+		st := c.Request().TLS
+		if st == nil || len(st.PeerCertificates) == 0 {
+			return echo.NewHTTPError(http.StatusForbidden, "client certificate required")
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         s.AdminClientCAs,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		for _, ic := range st.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(ic)
+		}
+
+		if _, err := st.PeerCertificates[0].Verify(opts); err != nil {
+			return echo.NewHTTPError(http.StatusForbidden, "invalid client certificate")
+		}
+
+		return next(c)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func testClientCert(t *testing.T) (*x509.CertPool, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "admin"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool, cert
+}
+
+func TestAdminClientCertRequired(t *testing.T) {
+	s := testServer(t)
+	pool, cert := testClientCert(t)
+	s.AdminClientCAs = pool
+
+	e := echo.New()
+	h := s.requireAdminClientCert(s.handleAdminInfo)
+
+	// no client cert at all
+	req := httptest.NewRequest("GET", "/admin/info", nil)
+	rec := httptest.NewRecorder()
+	err := h(e.NewContext(req, rec))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 403 {
+		t.Fatalf("expected 403 without client cert, got %v", err)
+	}
+
+	// valid client cert presented over tls
+	req = httptest.NewRequest("GET", "/admin/info", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rec = httptest.NewRecorder()
+	if err := h(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("expected valid client cert to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	UpdateStatusPending   = "pending"
+	UpdateStatusCommitted = "committed"
+	UpdateStatusFailed    = "failed"
+)
+
+type updateJob struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type queuedUpdate struct {
+	id  string
+	car []byte
+}
+
+// maxFinishedJobs caps how many completed jobs stay queryable. The
+// oldest get evicted so the jobs map cant grow without bound; pending
+// jobs are never evicted.
+const maxFinishedJobs = 1024
+
+type updateQueue struct {
+	lk   sync.Mutex
+	jobs map[string]*updateJob
+	// finished holds completed job ids oldest-first, for eviction
+	finished []string
+	work     chan queuedUpdate
+}
+
+func newUpdateQueue() *updateQueue {
+	return &updateQueue{
+		jobs: make(map[string]*updateJob),
+		work: make(chan queuedUpdate, 32),
+	}
+}
+
+func (s *Server) enqueueUpdate(car []byte) (*updateJob, error) {
+	idb := make([]byte, 8)
+	rand.Read(idb)
+
+	job := &updateJob{
+		ID:     hex.EncodeToString(idb),
+		Status: UpdateStatusPending,
+	}
+
+	s.Updates.lk.Lock()
+	s.Updates.jobs[job.ID] = job
+	s.Updates.lk.Unlock()
+
+	// the send must not block: a full queue with the handler parked on
+	// it, car in memory, is a cheap way to wedge the server. Shed load
+	// instead, same as commitCar does when the event bus saturates
+	select {
+	case s.Updates.work <- queuedUpdate{id: job.ID, car: car}:
+	default:
+		s.Updates.lk.Lock()
+		delete(s.Updates.jobs, job.ID)
+		s.Updates.lk.Unlock()
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, "update queue full, retry later")
+	}
+
+	return job, nil
+}
+
+// runUpdateWorker processes queued async updates one at a time.
+func (s *Server) runUpdateWorker(ctx context.Context) {
+	for {
+		select {
+		case qu := <-s.Updates.work:
+			err := s.runUpdate(ctx, bytes.NewReader(qu.car))
+
+			s.Updates.lk.Lock()
+			job := s.Updates.jobs[qu.id]
+			if err != nil {
+				job.Status = UpdateStatusFailed
+				job.Error = err.Error()
+			} else {
+				job.Status = UpdateStatusCommitted
+			}
+			s.Updates.finish(qu.id)
+			s.Updates.lk.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finish marks a job completed and evicts the oldest completed jobs
+// beyond the retention cap. Callers hold lk.
+func (q *updateQueue) finish(id string) {
+	q.finished = append(q.finished, id)
+	for len(q.finished) > maxFinishedJobs {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+func (s *Server) handleUpdateStatus(c echo.Context) error {
+	s.Updates.lk.Lock()
+	job, ok := s.Updates.jobs[c.Param("id")]
+	s.Updates.lk.Unlock()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "no such update: "+c.Param("id"))
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
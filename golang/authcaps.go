@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/qri-io/ucan"
+)
+
+type capabilitiesBody struct {
+	// Target is the handle or did the caller wants capabilities for.
+	Target string `json:"target"`
+}
+
+type grantedCap struct {
+	Cap      string `json:"cap"`
+	Resource string `json:"resource"`
+	Value    string `json:"value"`
+}
+
+// enumerateCaps lists the capabilities a token's attenuations grant
+// over a target account. This is the same containment logic the write
+// paths assert with, just enumerated instead of checked.
+func enumerateCaps(tok *ucan.Token, target string) []grantedCap {
+	out := []grantedCap{}
+	for _, att := range tok.Attenuations {
+		if att.Rsc.Contains(newAccountRsc(att.Rsc.Type(), target)) {
+			out = append(out, grantedCap{
+				Cap:      att.Cap.String(),
+				Resource: att.Rsc.Type(),
+				Value:    target,
+			})
+		}
+	}
+	return out
+}
+
+// handleAuthCapabilities reports what the presented token can do to a
+// target, for uis that show users what an app they authorized can do.
+func (s *Server) handleAuthCapabilities(c echo.Context) error {
+	var body capabilitiesBody
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid body")
+	}
+	if body.Target == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing target")
+	}
+
+	inline, err := s.extractInlineProofs(c.Request())
+	if err != nil {
+		return err
+	}
+	tok, err := s.parseUcan(c.Request())
+	if err != nil {
+		return err
+	}
+	if err := s.checkProofChain(tok, inline); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"target":       body.Target,
+		"capabilities": enumerateCaps(tok, body.Target),
+	})
+}
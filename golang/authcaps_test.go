@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/qri-io/ucan"
+)
+
+func TestEnumerateCaps(t *testing.T) {
+	tok := &ucan.Token{
+		Attenuations: ucan.Attenuations{
+			{Cap: twitterCaps.Cap("POST"), Rsc: newAccountRsc("twitter", "dholms")},
+		},
+	}
+
+	caps := enumerateCaps(tok, "dholms")
+	if len(caps) != 1 {
+		t.Fatalf("expected one capability for the granted account, got %v", caps)
+	}
+	if caps[0].Cap != "POST" || caps[0].Resource != "twitter" || caps[0].Value != "dholms" {
+		t.Fatalf("unexpected capability: %+v", caps[0])
+	}
+
+	if caps := enumerateCaps(tok, "alice"); len(caps) != 0 {
+		t.Fatalf("expected no capabilities for an ungranted account, got %v", caps)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// BlobStore is external storage (eg s3) for blobs that would bloat the
+// local blockstore. Implementations only deal in raw bytes keyed by cid.
+// Delete must succeed for blobs the store never held, purges dont know
+// which tier a block landed in.
+type BlobStore interface {
+	Put(ctx context.Context, c cid.Cid, data []byte) error
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	Delete(ctx context.Context, c cid.Cid) error
+}
+
+// tieredBlockstore routes large or raw-codec blocks to an external blob
+// store while keeping structural cbor local. It satisfies the normal
+// blockstore interface so nothing above it has to care.
+type tieredBlockstore struct {
+	blockstore.Blockstore
+	external  BlobStore
+	threshold int
+}
+
+// NewTieredBlockstore wraps local so blocks of the raw codec, or larger
+// than threshold bytes, live in ext instead.
+func NewTieredBlockstore(local blockstore.Blockstore, ext BlobStore, threshold int) blockstore.Blockstore {
+	return &tieredBlockstore{Blockstore: local, external: ext, threshold: threshold}
+}
+
+func (t *tieredBlockstore) isExternal(c cid.Cid, size int) bool {
+	return c.Prefix().Codec == cid.Raw || size > t.threshold
+}
+
+func (t *tieredBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	if t.isExternal(blk.Cid(), len(blk.RawData())) {
+		return t.external.Put(ctx, blk.Cid(), blk.RawData())
+	}
+	return t.Blockstore.Put(ctx, blk)
+}
+
+func (t *tieredBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := t.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBlock removes a block from both tiers. Without this a purge or
+// eviction would only clear the local copy and Get would quietly
+// resurrect the block from external storage.
+func (t *tieredBlockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	if err := t.Blockstore.DeleteBlock(ctx, c); err != nil {
+		return err
+	}
+	return t.external.Delete(ctx, c)
+}
+
+func (t *tieredBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := t.Blockstore.Get(ctx, c)
+	if err == nil {
+		return blk, nil
+	}
+
+	data, eerr := t.external.Get(ctx, c)
+	if eerr != nil {
+		// the local miss is the error callers expect to match on
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (t *tieredBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	has, err := t.Blockstore.Has(ctx, c)
+	if err == nil && has {
+		return true, nil
+	}
+	return t.external.Has(ctx, c)
+}
+
+// defaultBlobThreshold is the size above which blocks go external when
+// no explicit threshold is configured.
+const defaultBlobThreshold = 1 << 20
+
+// dirBlobStore is the simplest possible external store: one file per
+// blob in a flat directory, named by cid. It stands in for s3 in
+// single-machine deployments and keeps the -blob-dir flag useful
+// without any cloud dependency.
+type dirBlobStore struct {
+	dir string
+}
+
+// NewDirBlobStore opens (creating if needed) a directory-backed blob
+// store.
+func NewDirBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &dirBlobStore{dir: dir}, nil
+}
+
+func (d *dirBlobStore) path(c cid.Cid) string {
+	return filepath.Join(d.dir, c.String())
+}
+
+func (d *dirBlobStore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	return os.WriteFile(d.path(c), data, 0o644)
+}
+
+func (d *dirBlobStore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	return os.ReadFile(d.path(c))
+}
+
+func (d *dirBlobStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	if _, err := os.Stat(d.path(c)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *dirBlobStore) Delete(ctx context.Context, c cid.Cid) error {
+	if err := os.Remove(d.path(c)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
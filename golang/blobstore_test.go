@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// memBlobStore is a stand-in for an external store like s3.
+type memBlobStore struct {
+	blobs map[cid.Cid][]byte
+}
+
+func newMemBlobStore() *memBlobStore {
+	return &memBlobStore{blobs: make(map[cid.Cid][]byte)}
+}
+
+func (m *memBlobStore) Put(ctx context.Context, c cid.Cid, data []byte) error {
+	m.blobs[c] = data
+	return nil
+}
+
+func (m *memBlobStore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	data, ok := m.blobs[c]
+	if !ok {
+		return nil, fmt.Errorf("blob not found: %s", c)
+	}
+	return data, nil
+}
+
+func (m *memBlobStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	_, ok := m.blobs[c]
+	return ok, nil
+}
+
+func (m *memBlobStore) Delete(ctx context.Context, c cid.Cid) error {
+	delete(m.blobs, c)
+	return nil
+}
+
+func TestTieredBlockstoreRouting(t *testing.T) {
+	ctx := context.TODO()
+
+	local := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	ext := newMemBlobStore()
+	bs := NewTieredBlockstore(local, ext, 1024)
+
+	// small cbor stays local
+	small, err := cbornode.WrapObject(map[string]string{"hello": "world"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, small); err != nil {
+		t.Fatal(err)
+	}
+	if len(ext.blobs) != 0 {
+		t.Fatal("small cbor block should not go external")
+	}
+	if has, _ := local.Has(ctx, small.Cid()); !has {
+		t.Fatal("small cbor block should be in the local store")
+	}
+
+	// a raw blob goes external regardless of size
+	blob := blocks.NewBlock([]byte("some image bytes"))
+	if err := bs.Put(ctx, blob); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := local.Has(ctx, blob.Cid()); has {
+		t.Fatal("raw blob should not be in the local store")
+	}
+	if has, _ := ext.Has(ctx, blob.Cid()); !has {
+		t.Fatal("raw blob should be in the external store")
+	}
+
+	// reads come back through the tiered view transparently
+	got, err := bs.Get(ctx, blob.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.RawData(), blob.RawData()) {
+		t.Fatal("external blob came back with different bytes")
+	}
+	if has, _ := bs.Has(ctx, blob.Cid()); !has {
+		t.Fatal("tiered Has should see external blobs")
+	}
+}
+
+func TestConfigWiresBlobStore(t *testing.T) {
+	ctx := context.TODO()
+
+	ext := newMemBlobStore()
+	s, err := NewServer(Config{Blobs: ext})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := blocks.NewBlock([]byte("offloaded image bytes"))
+	if err := s.Blockstore.Put(ctx, blob); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := ext.Has(ctx, blob.Cid()); !has {
+		t.Fatal("a server built with Config.Blobs should route raw blocks externally")
+	}
+}
+
+func TestPurgeRemovesExternalBlobs(t *testing.T) {
+	ctx := context.TODO()
+
+	ext := newMemBlobStore()
+	s, err := NewServer(Config{Blobs: ext})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a user whose single record is a raw blob, which the tiered store
+	// routes to external storage
+	blob := blocks.NewBlock([]byte("external image bytes"))
+	if err := s.Blockstore.Put(ctx, blob); err != nil {
+		t.Fatal(err)
+	}
+
+	cst := cbornode.NewCborStore(s.Blockstore)
+	nd := hamt.NewNode(cst)
+	cc := cbg.CborCid(blob.Cid())
+	if err := nd.Set(ctx, "0", &cc); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cbornode.WrapObject(&User{
+		Name:      "alice",
+		NextPost:  1,
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	s.UserRoots["alice"] = user.Cid()
+	s.UserDids["alice"] = ""
+
+	if has, _ := ext.Has(ctx, blob.Cid()); !has {
+		t.Fatal("the raw blob should live in the external store")
+	}
+
+	if _, _, err := s.deleteUser(ctx, "alice", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := ext.Has(ctx, blob.Cid()); has {
+		t.Fatal("purge left the external copy behind")
+	}
+	if _, err := s.Blockstore.Get(ctx, blob.Cid()); err == nil {
+		t.Fatal("a purged blob must not be servable")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+type canonicalizeReport struct {
+	// Users whose root moved to a canonical encoding.
+	Users []string `json:"users"`
+	// NeedsResign lists users whose signed root covers a rewritten
+	// block, we cant fix those without invalidating the signature.
+	NeedsResign []string `json:"needsResign"`
+	// Rewritten counts re-encoded blocks.
+	Rewritten int `json:"rewritten"`
+}
+
+// handleCanonicalize is a one-time migration tool: it walks every
+// user's dag, re-encodes any block that wasnt canonical cbor, and
+// repoints roots at the rewritten dags. Users whose roots are signed
+// get flagged for re-signing instead of silently broken.
+func (s *Server) handleCanonicalize(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// snapshot the registry under the lock, the walk below commits new
+	// roots and must not iterate the live map while doing so
+	s.ulk.Lock()
+	roots := make(map[string]cid.Cid, len(s.UserRoots))
+	for name, root := range s.UserRoots {
+		roots[name] = root
+	}
+	s.ulk.Unlock()
+
+	var report canonicalizeReport
+	for name, root := range roots {
+		if !root.Defined() {
+			continue
+		}
+
+		// a signed root covers the exact bytes below it, so dont touch it
+		blk, err := s.Blockstore.Get(ctx, root)
+		if err != nil {
+			return err
+		}
+		var sr SignedRoot
+		if err := cbornode.DecodeInto(blk.RawData(), &sr); err == nil && sr.User.Defined() {
+			newUser, n, err := s.canonicalizeDag(ctx, sr.User)
+			if err != nil {
+				return err
+			}
+			report.Rewritten += n
+			if newUser != sr.User {
+				report.NeedsResign = append(report.NeedsResign, name)
+			}
+			continue
+		}
+
+		newRoot, n, err := s.canonicalizeDag(ctx, root)
+		if err != nil {
+			return err
+		}
+		report.Rewritten += n
+		if newRoot != root {
+			s.updateUserRoot(name, newRoot)
+			report.Users = append(report.Users, name)
+		}
+	}
+
+	return c.JSON(http.StatusOK, &report)
+}
+
+// canonicalizeDag re-encodes a dag bottom-up, returning the (possibly
+// new) root cid and how many blocks changed.
+func (s *Server) canonicalizeDag(ctx context.Context, c cid.Cid) (cid.Cid, int, error) {
+	if c.Prefix().Codec == cid.Raw {
+		return c, 0, nil
+	}
+
+	blk, err := s.Blockstore.Get(ctx, c)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	var val interface{}
+	if err := cbornode.DecodeInto(blk.RawData(), &val); err != nil {
+		return cid.Undef, 0, err
+	}
+
+	var rewritten int
+	newVal, err := s.canonicalizeValue(ctx, val, &rewritten)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	nd, err := cbornode.WrapObject(newVal, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	if nd.Cid() == c {
+		return c, rewritten, nil
+	}
+
+	if err := s.Blockstore.Put(ctx, nd); err != nil {
+		return cid.Undef, 0, err
+	}
+	return nd.Cid(), rewritten + 1, nil
+}
+
+// canonicalizeValue walks a decoded node, canonicalizing every linked
+// child and rewriting the links to match.
+func (s *Server) canonicalizeValue(ctx context.Context, v interface{}, rewritten *int) (interface{}, error) {
+	switch t := v.(type) {
+	case cid.Cid:
+		nc, n, err := s.canonicalizeDag(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		*rewritten += n
+		return nc, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			ne, err := s.canonicalizeValue(ctx, e, rewritten)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = ne
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			ne, err := s.canonicalizeValue(ctx, e, rewritten)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = ne
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestCanonicalizeRewritesLegacyBlocks(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// a legacy map encoded with keys out of canonical order: {"b":1,"a":2}
+	raw := []byte{0xa2, 0x61, 'b', 0x01, 0x61, 'a', 0x02}
+	pref := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}
+	c, err := pref.Sum(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(raw, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	s.UserRoots["legacy"] = c
+	s.UserDids["legacy"] = "did:key:zLegacy"
+
+	e := echo.New()
+	req := httptest.NewRequest("POST", "/admin/canonicalize", nil)
+	rec := httptest.NewRecorder()
+	if err := s.handleCanonicalize(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+
+	newRoot := s.UserRoots["legacy"]
+	if newRoot == c {
+		t.Fatal("expected the legacy root to be rewritten")
+	}
+
+	nblk, err := s.Blockstore.Get(ctx, newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// canonical encoding puts "a" first
+	if nblk.RawData()[2] != 'a' {
+		t.Fatalf("rewritten block is not canonical: %x", nblk.RawData())
+	}
+}
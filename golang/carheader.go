@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+)
+
+// validateCarHeaderBytes runs the header checks on an already-framed
+// header block and hands back the declared roots, so the streaming
+// upload path can validate and keep reading without a second parse.
+func validateCarHeaderBytes(hb []byte) ([]cid.Cid, error) {
+	var hdr map[string]interface{}
+	if err := cbornode.DecodeInto(hb, &hdr); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("car header is not valid cbor: %s", err))
+	}
+
+	ver, ok := headerInt(hdr["version"])
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "car header missing version")
+	}
+	if ver == 2 {
+		// carv2 wraps a v1 payload in an outer header with
+		// characteristics bits, none of which we speak
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "carv2 uploads are not supported")
+	}
+	if ver != 1 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("unsupported car version %d, only version 1 is accepted", ver))
+	}
+
+	roots, ok := hdr["roots"].([]interface{})
+	if !ok || roots == nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "car header missing roots")
+	}
+	if len(roots) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "car header declares no roots")
+	}
+
+	out := make([]cid.Cid, 0, len(roots))
+	for _, r := range roots {
+		c, ok := r.(cid.Cid)
+		if !ok {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "car header roots are not cids")
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// headerInt unpacks whichever integer type the cbor decoder produced.
+func headerInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
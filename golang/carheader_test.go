@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// buildRawCarHeader serializes an arbitrary header map the way a car
+// file would carry it.
+func buildRawCarHeader(t *testing.T, hdr map[string]interface{}) []byte {
+	t.Helper()
+	hb, err := cbornode.DumpObject(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := carutil.LdWrite(buf, hb); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCarHeaderValidation(t *testing.T) {
+	pref := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}
+	someCid, err := pref.Sum([]byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		hdr  map[string]interface{}
+		want string
+	}{
+		{"unsupported version", map[string]interface{}{"version": 3, "roots": []cid.Cid{someCid}}, "unsupported car version 3"},
+		{"carv2", map[string]interface{}{"version": 2, "roots": []cid.Cid{someCid}}, "carv2"},
+		{"missing version", map[string]interface{}{"roots": []cid.Cid{someCid}}, "missing version"},
+		{"missing roots", map[string]interface{}{"version": 1}, "missing roots"},
+		{"empty roots", map[string]interface{}{"version": 1, "roots": []cid.Cid{}}, "no roots"},
+	}
+	for _, tc := range cases {
+		hb, err := cbornode.DumpObject(tc.hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = validateCarHeaderBytes(hb)
+		he, ok := err.(*echo.HTTPError)
+		if !ok || he.Code != 400 {
+			t.Fatalf("%s: expected 400, got %v", tc.name, err)
+		}
+		if !strings.Contains(fmt.Sprint(he.Message), tc.want) {
+			t.Fatalf("%s: error %q should mention %q", tc.name, he.Message, tc.want)
+		}
+	}
+
+	// a well-formed v1 header passes
+	hb, err := cbornode.DumpObject(map[string]interface{}{
+		"version": 1, "roots": []cid.Cid{someCid},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validateCarHeaderBytes(hb); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateRejectsBadCarHeader(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	data := buildRawCarHeader(t, map[string]interface{}{"version": 1})
+	err := s.runUpdate(ctx, bytes.NewReader(data))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 from the update path, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Car order policies. The convention is children before (or alongside)
+// their parents, so that by the time a block appears everything it
+// links to has already been seen. Buffering makes order irrelevant for
+// correctness, but a client emitting forward references is usually
+// broken in other ways too, so operators can opt in to catching it.
+const (
+	CarOrderIgnore = ""
+	CarOrderWarn   = "warn"
+	CarOrderReject = "reject"
+)
+
+// orderTracker is the single-pass form of the topological order check.
+// A link to a cid not seen yet is provisionally fine, it may point at
+// an unchanged part of the dag the server already holds; but if that
+// cid then shows up later in the stream, the referencing block got
+// ahead of its child.
+type orderTracker struct {
+	seen map[cid.Cid]bool
+	// pending maps a not-yet-seen link target to the first block that
+	// referenced it
+	pending map[cid.Cid]cid.Cid
+}
+
+func newOrderTracker() *orderTracker {
+	return &orderTracker{
+		seen:    make(map[cid.Cid]bool),
+		pending: make(map[cid.Cid]cid.Cid),
+	}
+}
+
+// observe feeds one block, in stream order, into the check.
+func (o *orderTracker) observe(c cid.Cid, data []byte) error {
+	if by, ok := o.pending[c]; ok {
+		return fmt.Errorf("block %s references %s before it appears", by, c)
+	}
+	o.seen[c] = true
+
+	if c.Prefix().Codec != cid.DagCBOR {
+		return nil
+	}
+	return cbg.ScanForLinks(bytes.NewReader(data), func(l cid.Cid) {
+		if !o.seen[l] {
+			if _, ok := o.pending[l]; !ok {
+				o.pending[l] = c
+			}
+		}
+	})
+}
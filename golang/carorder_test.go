@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// childFirstCar builds a car whose blocks respect the convention:
+// the posts root appears before the user block that links it.
+func childFirstCar(t *testing.T) []byte {
+	t.Helper()
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	postsRoot, err := HamtCollection().New(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postsBlk, err := bs.Get(ctx, postsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		Name:      "alice",
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := car.WriteHeader(&car.CarHeader{
+		Roots:   []cid.Cid{user.Cid()},
+		Version: 1,
+	}, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.LdWrite(buf, postsBlk.Cid().Bytes(), postsBlk.RawData()); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.LdWrite(buf, user.Cid().Bytes(), user.RawData()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOrderTracker(t *testing.T) {
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	postsRoot, err := HamtCollection().New(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postsBlk, err := bs.Get(ctx, postsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cbornode.WrapObject(&User{
+		Name:      "alice",
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// child before parent is the convention
+	tr := newOrderTracker()
+	if err := tr.observe(postsBlk.Cid(), postsBlk.RawData()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.observe(user.Cid(), user.RawData()); err != nil {
+		t.Fatalf("child-first order should pass: %s", err)
+	}
+
+	// the parent forward-referencing a child that shows up later fails
+	tr = newOrderTracker()
+	if err := tr.observe(user.Cid(), user.RawData()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.observe(postsBlk.Cid(), postsBlk.RawData()); err == nil {
+		t.Fatal("expected a forward reference to fail the order check")
+	}
+}
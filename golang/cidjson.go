@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/ipfs/go-cid"
+)
+
+// renderCid is the one place cids become strings for responses, so
+// every endpoint agrees on the encoding. The default is the cid's own
+// canonical form (base32 for v1); operators can force another multibase
+// via CidBase.
+func (s *Server) renderCid(c cid.Cid) string {
+	if !c.Defined() {
+		return ""
+	}
+	if s.CidBase != 0 {
+		if str, err := c.StringOfBase(s.CidBase); err == nil {
+			return str
+		}
+	}
+	return c.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	multibase "github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestCidRenderingConsistentAcrossEndpoints(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.CidBase = multibase.Base58BTC
+
+	nd, err := cbornode.WrapObject(map[string]string{"v": "x"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	s.UserRoots["alice"] = nd.Cid()
+	s.UserDids["alice"] = "did:key:zA"
+	s.recordHistory("alice", nd.Cid())
+
+	want, err := nd.Cid().StringOfBase(multibase.Base58BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+
+	// the history endpoint renders in the configured base
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s.handleUserHistory(c); err != nil {
+		t.Fatal(err)
+	}
+	var hist struct {
+		Roots []string `json:"roots"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &hist); err != nil {
+		t.Fatal(err)
+	}
+	if len(hist.Roots) != 1 || hist.Roots[0] != want {
+		t.Fatalf("history rendered %v, want %q", hist.Roots, want)
+	}
+
+	// the quarantine endpoint renders the same cid identically
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest("POST", "/", nil), rec)
+	c.SetParamNames("cid")
+	c.SetParamValues(nd.Cid().String())
+	if err := s.handleQuarantineBlock(c); err != nil {
+		t.Fatal(err)
+	}
+	var q struct {
+		Cid string `json:"cid"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Cid != want {
+		t.Fatalf("quarantine rendered %q, want %q", q.Cid, want)
+	}
+}
+
+func TestRenderCidDefaults(t *testing.T) {
+	s := testServer(t)
+	if s.renderCid(cid.Undef) != "" {
+		t.Fatal("undefined cids render empty")
+	}
+
+	nd, err := cbornode.WrapObject(map[string]string{"v": "y"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.renderCid(nd.Cid()) != nd.Cid().String() {
+		t.Fatal("unconfigured servers keep the canonical form")
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// CollectionDef describes how a named collection is represented on disk.
+// New builds the empty root for a fresh collection, Check validates that
+// an uploaded root is structurally the right kind of node, and
+// ValidateRecord (optional) is run over every leaf record during update
+// validation.
+type CollectionDef struct {
+	New            func(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error)
+	Check          func(nd *cbornode.Node) error
+	ValidateRecord func(key string, data []byte) error
+}
+
+// HamtCollection is the default representation, matching what the posts
+// feed has always used.
+func HamtCollection() CollectionDef {
+	return CollectionDef{
+		New: func(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error) {
+			cst := cbornode.NewCborStore(bs)
+			nd := hamt.NewNode(cst)
+			return cst.Put(ctx, nd)
+		},
+		Check: func(nd *cbornode.Node) error {
+			// a hamt node serializes as a [bitfield, pointers] pair
+			arr, ok := nodeValue(nd).([]interface{})
+			if !ok || len(arr) != 2 {
+				return fmt.Errorf("collection root is not a hamt node")
+			}
+			return nil
+		},
+	}
+}
+
+// PostsCollection is the posts hamt with the Post schema enforced on
+// every leaf record.
+func PostsCollection() CollectionDef {
+	def := HamtCollection()
+	def.ValidateRecord = validatePostRecord
+	return def
+}
+
+// validatePostRecord decodes a posts leaf into the Post shape and
+// checks its invariants.
+func validatePostRecord(key string, data []byte) error {
+	var p Post
+	if err := cbornode.DecodeInto(data, &p); err != nil {
+		return fmt.Errorf("not a post record: %s", err)
+	}
+	return p.Validate()
+}
+
+// ListCollection is a plain cbor array, for small collections that dont
+// warrant a hamt.
+func ListCollection() CollectionDef {
+	return CollectionDef{
+		New: func(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error) {
+			nd, err := cbornode.WrapObject([]interface{}{}, mh.SHA2_256, -1)
+			if err != nil {
+				return cid.Undef, err
+			}
+			if err := bs.Put(ctx, nd); err != nil {
+				return cid.Undef, err
+			}
+			return nd.Cid(), nil
+		},
+		Check: func(nd *cbornode.Node) error {
+			if _, ok := nodeValue(nd).([]interface{}); !ok {
+				return fmt.Errorf("collection root is not a list")
+			}
+			return nil
+		},
+	}
+}
+
+func nodeValue(nd *cbornode.Node) interface{} {
+	v, _, err := nd.Resolve(nil)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// collectionDef looks up the configured representation for a collection,
+// defaulting to the hamt everything used before this was configurable.
+func (s *Server) collectionDef(name string) CollectionDef {
+	if def, ok := s.Collections[name]; ok {
+		return def
+	}
+	if name == "posts" && s.ValidatePosts {
+		return PostsCollection()
+	}
+	return HamtCollection()
+}
+
+// getEmptyCollectionRoot bootstraps an empty collection of the
+// configured type.
+func (s *Server) getEmptyCollectionRoot(ctx context.Context, bs blockstore.Blockstore, name string) (cid.Cid, error) {
+	return s.collectionDef(name).New(ctx, bs)
+}
+
+// layeredBs reads from the update's temporary store first, falling back
+// to blocks the server already holds for parts of the dag that didnt
+// change.
+type layeredBs struct {
+	blockstore.Blockstore
+	fallback blockstore.Blockstore
+}
+
+func (l *layeredBs) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := l.Blockstore.Get(ctx, c)
+	if err == nil {
+		return blk, nil
+	}
+	return l.fallback.Get(ctx, c)
+}
+
+func (l *layeredBs) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	has, err := l.Blockstore.Has(ctx, c)
+	if err == nil && has {
+		return true, nil
+	}
+	return l.fallback.Has(ctx, c)
+}
+
+// validateCollectionRecords runs the configured record validator over
+// every leaf of a hamt collection in an uploaded update.
+func (s *Server) validateCollectionRecords(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, name string) error {
+	def := s.collectionDef(name)
+	if def.ValidateRecord == nil {
+		return nil
+	}
+
+	layered := &layeredBs{Blockstore: bs, fallback: s.Blockstore}
+	cst := cbornode.NewCborStore(layered)
+
+	nd, err := hamt.LoadNode(ctx, cst, root)
+	if err != nil {
+		return err
+	}
+
+	return nd.ForEach(ctx, func(k string, val *cbg.Deferred) error {
+		var cc cbg.CborCid
+		if err := cc.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
+			return fmt.Errorf("collection %s value for %q was not a cid: %w", name, k, err)
+		}
+
+		blk, err := layered.Get(ctx, cid.Cid(cc))
+		if err != nil {
+			return err
+		}
+
+		if err := def.ValidateRecord(k, blk.RawData()); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("record %q does not conform to the %s schema: %s", k, name, err))
+		}
+		return nil
+	})
+}
+
+// countHamtEntries walks a hamt collection and counts its leaves.
+func (s *Server) countHamtEntries(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) (int64, error) {
+	layered := &layeredBs{Blockstore: bs, fallback: s.Blockstore}
+	nd, err := hamt.LoadNode(ctx, cbornode.NewCborStore(layered), root)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if err := nd.ForEach(ctx, func(k string, val *cbg.Deferred) error {
+		n++
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// checkNextPostFree rejects user blocks whose NextPost maps to a key
+// already occupied in the posts hamt. Appending with that state would
+// silently overwrite an existing post.
+// checkNextPostMonotonic refuses updates that move a user's NextPost
+// counter backwards. Post keys are allocated from the counter, so a
+// regression would hand out ids that old posts already own.
+func (s *Server) checkNextPostMonotonic(ctx context.Context, u *User) error {
+	prev, ok := s.lookupRoot(u.Name)
+	if !ok || !prev.Defined() {
+		return nil
+	}
+
+	old, _, err := s.decodeUserRoot(ctx, s.Blockstore, prev)
+	if err != nil {
+		// an undecodable previous root shouldnt block a recovery commit
+		return nil
+	}
+
+	if u.NextPost < old.NextPost {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("next post counter regressed from %d to %d", old.NextPost, u.NextPost))
+	}
+	return nil
+}
+
+func (s *Server) checkNextPostFree(ctx context.Context, bs blockstore.Blockstore, u *User) error {
+	layered := &layeredBs{Blockstore: bs, fallback: s.Blockstore}
+	nd, err := hamt.LoadNode(ctx, cbornode.NewCborStore(layered), u.PostsRoot)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%d", u.NextPost)
+	found, err := nd.Find(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	if found {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("next post key %q is already occupied", key))
+	}
+	return nil
+}
+
+// hamtKeys collects the key set of a hamt collection.
+func (s *Server) hamtKeys(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) (map[string]bool, error) {
+	layered := &layeredBs{Blockstore: bs, fallback: s.Blockstore}
+	nd, err := hamt.LoadNode(ctx, cbornode.NewCborStore(layered), root)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	if err := nd.ForEach(ctx, func(k string, val *cbg.Deferred) error {
+		keys[k] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// validateDeclaredDeletes checks a client's declared post deletions
+// against the key diff between the previous root and the update. A
+// "delete" of a key that never existed, or one that is somehow still
+// present, means the client's view of the tree has drifted from ours.
+func (s *Server) validateDeclaredDeletes(ctx context.Context, bs blockstore.Blockstore, u *User, deletes []string) error {
+	if len(deletes) == 0 {
+		return nil
+	}
+
+	oldRoot, ok := s.lookupRoot(u.Name)
+	if !ok || !oldRoot.Defined() {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			"cannot declare deletes on a first commit")
+	}
+	oldU, _, err := s.decodeUserRoot(ctx, s.Blockstore, oldRoot)
+	if err != nil {
+		return err
+	}
+
+	oldKeys, err := s.hamtKeys(ctx, s.Blockstore, oldU.PostsRoot)
+	if err != nil {
+		return err
+	}
+	newKeys, err := s.hamtKeys(ctx, bs, u.PostsRoot)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range deletes {
+		if !oldKeys[k] {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("declared delete of %q, which was never present", k))
+		}
+		if newKeys[k] {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("declared delete of %q, but the update still holds it", k))
+		}
+	}
+	return nil
+}
+
+// checkCollectionRoot validates an uploaded collection root against the
+// configured representation.
+func (s *Server) checkCollectionRoot(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, name string) error {
+	blk, err := bs.Get(ctx, root)
+	if err != nil {
+		// the root may be unchanged from a previous update
+		blk, err = s.Blockstore.Get(ctx, root)
+		if err != nil {
+			return fmt.Errorf("collection root %s not found: %w", root, err)
+		}
+	}
+
+	nd, err := cbornode.DecodeBlock(blk)
+	if err != nil {
+		return err
+	}
+
+	return s.collectionDef(name).Check(nd.(*cbornode.Node))
+}
+
+// defaultMaxCollectionsPerUser bounds per-user collection sprawl, a
+// user shouldnt be able to bloat server metadata with empty
+// collections.
+const defaultMaxCollectionsPerUser = 16
+
+func (s *Server) maxCollectionsPerUser() int {
+	if s.MaxCollectionsPerUser > 0 {
+		return s.MaxCollectionsPerUser
+	}
+	return defaultMaxCollectionsPerUser
+}
+
+// checkCollectionLimit enforces the per-user collection cap over a
+// commit. Only collections absent from the previous commit count as
+// new, so a user already over a lowered cap can still update what they
+// have.
+func (s *Server) checkCollectionLimit(cur, prev map[string]cid.Cid) error {
+	if len(cur) <= s.maxCollectionsPerUser() {
+		return nil
+	}
+
+	var added []string
+	for name := range cur {
+		if _, ok := prev[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	sort.Strings(added)
+
+	return echo.NewHTTPError(http.StatusForbidden,
+		fmt.Sprintf("new collection %q would exceed the limit of %d collections",
+			added[0], s.maxCollectionsPerUser()))
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// requireField builds a record validator that insists each record is a
+// cbor map carrying the named field.
+func requireField(field string) func(string, []byte) error {
+	return func(k string, data []byte) error {
+		nd, err := cbornode.Decode(data, mh.SHA2_256, -1)
+		if err != nil {
+			return err
+		}
+		if _, _, err := nd.Resolve([]string{field}); err != nil {
+			return fmt.Errorf("missing field %q", field)
+		}
+		return nil
+	}
+}
+
+func TestPerCollectionValidators(t *testing.T) {
+	ctx := context.TODO()
+
+	postsDef := HamtCollection()
+	postsDef.ValidateRecord = requireField("text")
+	followsDef := HamtCollection()
+	followsDef.ValidateRecord = requireField("did")
+
+	s := testServer(t)
+	s.Collections = map[string]CollectionDef{
+		"posts":   postsDef,
+		"follows": followsDef,
+	}
+
+	// a posts-shaped record stored under a single-entry hamt
+	putTestUserRecord(t, s, "alice", map[string]string{"text": "hello"})
+	u, err := s.getUserObj(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.validateCollectionRecords(ctx, s.Blockstore, u.PostsRoot, "posts"); err != nil {
+		t.Fatalf("posts record should pass the posts schema: %s", err)
+	}
+	if err := s.validateCollectionRecords(ctx, s.Blockstore, u.PostsRoot, "follows"); err == nil {
+		t.Fatal("posts record should fail the follows schema")
+	}
+}
+
+func TestConfigurableCollectionFactories(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.Collections = map[string]CollectionDef{
+		"posts":   HamtCollection(),
+		"follows": ListCollection(),
+	}
+
+	hamtRoot, err := s.getEmptyCollectionRoot(ctx, s.Blockstore, "posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listRoot, err := s.getEmptyCollectionRoot(ctx, s.Blockstore, "follows")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.checkCollectionRoot(ctx, s.Blockstore, hamtRoot, "posts"); err != nil {
+		t.Fatalf("hamt root should validate as posts: %s", err)
+	}
+	if err := s.checkCollectionRoot(ctx, s.Blockstore, listRoot, "follows"); err != nil {
+		t.Fatalf("list root should validate as follows: %s", err)
+	}
+
+	// cross-checking the types should fail both ways
+	if err := s.checkCollectionRoot(ctx, s.Blockstore, listRoot, "posts"); err == nil {
+		t.Fatal("list root should not validate as a hamt collection")
+	}
+	if err := s.checkCollectionRoot(ctx, s.Blockstore, hamtRoot, "follows"); err == nil {
+		t.Fatal("hamt root should not validate as a list collection")
+	}
+}
+
+func TestNextPostCollisionRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	nd := hamt.NewNode(cst)
+
+	val, err := cbornode.WrapObject(map[string]string{"text": "hi"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+	cc := cbg.CborCid(val.Cid())
+	if err := nd.Set(ctx, "0", &cc); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NextPost points at the occupied key, appending would overwrite
+	err = s.checkNextPostFree(ctx, bs, &User{NextPost: 0, PostsRoot: postsRoot})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("expected a 400 for the collision, got %v", err)
+	}
+
+	if err := s.checkNextPostFree(ctx, bs, &User{NextPost: 1, PostsRoot: postsRoot}); err != nil {
+		t.Fatalf("a free next key should pass: %s", err)
+	}
+}
+
+func TestDeclaredDeleteValidation(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// previous root holds keys "0" and "1"
+	putTestUser(t, s, "alice", []string{"0", "1"})
+
+	// the update drops "0"
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	nd := hamt.NewNode(cst)
+	val, err := cbornode.WrapObject(map[string]string{"stub": "post"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+	cc := cbg.CborCid(val.Cid())
+	if err := nd.Set(ctx, "1", &cc); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	newRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &User{Name: "alice", PostsRoot: newRoot}
+
+	// deleting a key that existed is fine
+	if err := s.validateDeclaredDeletes(ctx, bs, u, []string{"0"}); err != nil {
+		t.Fatalf("delete of an existing key should pass: %s", err)
+	}
+
+	// a key that was never present is a phantom delete
+	err = s.validateDeclaredDeletes(ctx, bs, u, []string{"7"})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("expected a 400 for a phantom delete, got %v", err)
+	}
+
+	// declaring a delete while the key survives is drift too
+	err = s.validateDeclaredDeletes(ctx, bs, u, []string{"1"})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("expected a 400 for a still-present key, got %v", err)
+	}
+}
+
+func TestCollectionLimit(t *testing.T) {
+	s := testServer(t)
+	s.MaxCollectionsPerUser = 2
+
+	pref := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}
+	mkCid := func(seed string) cid.Cid {
+		c, err := pref.Sum([]byte(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	two := map[string]cid.Cid{"posts": mkCid("a"), "likes": mkCid("b")}
+	three := map[string]cid.Cid{"posts": mkCid("a"), "likes": mkCid("b"), "media": mkCid("c")}
+
+	// at the cap is fine, a brand new collection past it is not
+	if err := s.checkCollectionLimit(two, nil); err != nil {
+		t.Fatal(err)
+	}
+	err := s.checkCollectionLimit(three, two)
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 403 {
+		t.Fatalf("expected 403 past the collection cap, got %v", err)
+	}
+	if !strings.Contains(fmt.Sprint(he.Message), "media") {
+		t.Fatalf("error should name the new collection: %v", he.Message)
+	}
+
+	// updates to collections that already exist stay allowed, even when
+	// the user is over a lowered cap
+	s.MaxCollectionsPerUser = 1
+	if err := s.checkCollectionLimit(two, two); err != nil {
+		t.Fatal(err)
+	}
+
+	// the single posts collection every real update carries is under any cap
+	ctx := context.TODO()
+	_, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostRoundTrip(t *testing.T) {
+	parent, err := cbornode.WrapObject(map[string]string{"text": "first"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Post{
+		Text:      "a reply",
+		CreatedAt: "2026-08-28T12:00:00Z",
+		Reply:     &PostRef{Root: parent.Cid(), Parent: parent.Cid()},
+	}
+	nd, err := cbornode.WrapObject(p, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Post
+	if err := cbornode.DecodeInto(nd.RawData(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != p.Text || got.CreatedAt != p.CreatedAt {
+		t.Fatalf("post did not round-trip: %+v", got)
+	}
+	if got.Reply == nil || got.Reply.Parent != parent.Cid() || got.Reply.Root != parent.Cid() {
+		t.Fatalf("reply refs did not round-trip: %+v", got.Reply)
+	}
+
+	// a plain post without reply or timestamp round-trips too
+	plain, err := cbornode.WrapObject(&Post{Text: "hi"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got2 Post
+	if err := cbornode.DecodeInto(plain.RawData(), &got2); err != nil {
+		t.Fatal(err)
+	}
+	if got2.Text != "hi" || got2.Reply != nil {
+		t.Fatalf("plain post did not round-trip: %+v", got2)
+	}
+}
+
+func TestValidatePostsSchema(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.ValidatePosts = true
+
+	// a well-formed post passes
+	putTestUserRecord(t, s, "alice", map[string]string{
+		"text": "hello", "createdAt": "2026-08-28T12:00:00Z",
+	})
+	u, err := s.getUserObj(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.validateCollectionRecords(ctx, s.Blockstore, u.PostsRoot, "posts"); err != nil {
+		t.Fatalf("well-formed post should validate: %s", err)
+	}
+
+	// a record that isnt post-shaped is refused with a 400
+	putTestUserRecord(t, s, "bob", map[string]int{"likes": 3})
+	u, err = s.getUserObj(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.validateCollectionRecords(ctx, s.Blockstore, u.PostsRoot, "posts")
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a malformed post, got %v", err)
+	}
+
+	// a textless post fails even though it decodes
+	putTestUserRecord(t, s, "carol", map[string]string{"createdAt": "2026-08-28T12:00:00Z"})
+	u, err = s.getUserObj(ctx, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.validateCollectionRecords(ctx, s.Blockstore, u.PostsRoot, "posts"); err == nil {
+		t.Fatal("a post without text should be refused")
+	}
+}
+
+func TestNextPostMonotonic(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// counter starts at 1 and moving it forward is fine
+	_, car1 := buildUserCarWithPosts(t, "alice", 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(car1)); err != nil {
+		t.Fatal(err)
+	}
+	_, car2 := buildUserCarWithPosts(t, "alice", 2)
+	if err := s.runUpdate(ctx, bytes.NewReader(car2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// holding it steady is allowed, deletes shrink the tree but the
+	// counter never rewinds
+	_, flat := testUserCar(t, "alice", 2)
+	if err := s.runUpdate(ctx, bytes.NewReader(flat)); err != nil {
+		t.Fatal(err)
+	}
+
+	// regressing it is refused, those ids were already handed out
+	err := s.runUpdate(ctx, bytes.NewReader(car1))
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a counter regression, got %v", err)
+	}
+	if !strings.Contains(fmt.Sprint(he.Message), "regressed") {
+		t.Fatalf("error should mention the regression: %v", he.Message)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// Config collects a Server's dependencies and tunables in one place.
+// Every field may be left zero: nil stores default to in-memory
+// implementations and zero limits mean each one's built-in default, so
+// tests can build a working server from an empty Config and override
+// only what they exercise.
+type Config struct {
+	// Blockstore holds the repo blocks, an in-memory store when nil.
+	Blockstore blockstore.Blockstore
+
+	// Blobs, when set, receives raw-codec blocks and anything over
+	// BlobThreshold bytes (defaultBlobThreshold when zero), keeping bulky
+	// blobs out of the main blockstore.
+	Blobs         BlobStore
+	BlobThreshold int
+
+	// Roots persists the user maps across restarts. Nil keeps them
+	// memory-only, which is fine for tests and throwaway servers.
+	Roots RootStore
+
+	// Key is the server's own signing identity. Nil keeps the legacy
+	// shared TwitterDid identity.
+	Key *ServerKey
+
+	// Ucans and Resolver back token verification, defaulted when nil.
+	Ucans    *UcanStore
+	Resolver *DidResolver
+
+	// Keys caches signing keys for signed-root verification. The default
+	// cache only knows did:key; supply a cache with a custom resolver to
+	// support document-backed methods like did:web.
+	Keys *KeyCache
+
+	// Walks and Metrics receive observations; defaulted when nil so a
+	// NewServer-built server always exports /metrics.
+	Walks   *WalkMetrics
+	Metrics *Metrics
+
+	// Logger receives structured operation logs, the process-wide slog
+	// default when nil.
+	Logger *slog.Logger
+
+	// Clock supplies the current time for token validity checks, real
+	// time when nil.
+	Clock func() time.Time
+
+	// Limits, zero means the built-in default for each.
+	MaxUpdateBytes int64
+	MaxWalkBlocks  int
+	MaxGraphDepth  int
+	MaxStoreBytes  int64
+	HistoryDepth   int
+
+	// StorePolicy ("reject" or "evict") and CarOrderPolicy (ignore,
+	// "warn" or "reject") as documented on Server. Validated here so a
+	// typo fails at startup rather than at the first update.
+	StorePolicy    string
+	CarOrderPolicy string
+
+	ReadOnly      bool
+	ServerInit    bool
+	ValidatePosts bool
+	Debug         bool
+}
+
+// NewServer wires a Server from cfg, filling in defaults for anything
+// unset. The returned server is ready for handlers but has not loaded
+// persisted state, callers with a root store follow up with
+// loadPersistedUsers.
+func NewServer(cfg Config) (*Server, error) {
+	switch cfg.StorePolicy {
+	case "", "reject", "evict":
+	default:
+		return nil, fmt.Errorf("unknown store policy %q", cfg.StorePolicy)
+	}
+	switch cfg.CarOrderPolicy {
+	case CarOrderIgnore, CarOrderWarn, CarOrderReject:
+	default:
+		return nil, fmt.Errorf("unknown car order policy %q", cfg.CarOrderPolicy)
+	}
+
+	bs := cfg.Blockstore
+	if bs == nil {
+		bs = blockstore.NewBlockstore(datastore.NewMapDatastore())
+	}
+	if cfg.Blobs != nil {
+		threshold := cfg.BlobThreshold
+		if threshold <= 0 {
+			threshold = defaultBlobThreshold
+		}
+		bs = NewTieredBlockstore(bs, cfg.Blobs, threshold)
+	}
+	ucans := cfg.Ucans
+	if ucans == nil {
+		ucans = NewUcanStore()
+	}
+	resolver := cfg.Resolver
+	if resolver == nil {
+		resolver = NewDidResolver(defaultDidFetch)
+	}
+	walks := cfg.Walks
+	if walks == nil {
+		walks = NewWalkMetrics()
+	}
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = NewMetrics()
+	}
+	keys := cfg.Keys
+	if keys == nil {
+		keys = NewKeyCache(resolveSigningKey)
+	}
+
+	return &Server{
+		Blockstore: bs,
+		Roots:      cfg.Roots,
+		Key:        cfg.Key,
+		UserRoots:  make(map[string]cid.Cid),
+		UserDids:   make(map[string]string),
+		Updates:    newUpdateQueue(),
+		RootLog:    &RootLog{},
+		Events:     NewEventBus(),
+		Resolver:   resolver,
+		Ucans:      ucans,
+		Keys:       keys,
+		Walks:      walks,
+		Metrics:    metrics,
+		Logger:     cfg.Logger,
+		Clock:      cfg.Clock,
+
+		MaxUpdateBytes: cfg.MaxUpdateBytes,
+		MaxWalkBlocks:  cfg.MaxWalkBlocks,
+		MaxGraphDepth:  cfg.MaxGraphDepth,
+		MaxStoreBytes:  cfg.MaxStoreBytes,
+		HistoryDepth:   cfg.HistoryDepth,
+		StorePolicy:    cfg.StorePolicy,
+		CarOrderPolicy: cfg.CarOrderPolicy,
+
+		ReadOnly:      cfg.ReadOnly,
+		ServerInit:    cfg.ServerInit,
+		ValidatePosts: cfg.ValidatePosts,
+		Debug:         cfg.Debug,
+	}, nil
+}
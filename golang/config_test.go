@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNewServerDefaults(t *testing.T) {
+	ctx := context.TODO()
+
+	s, err := NewServer(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every dependency is defaulted, nothing should be nil
+	if s.Blockstore == nil || s.Ucans == nil || s.Resolver == nil ||
+		s.Walks == nil || s.Metrics == nil || s.Events == nil ||
+		s.UserRoots == nil || s.UserDids == nil {
+		t.Fatal("NewServer left a dependency unset")
+	}
+
+	// the defaulted server handles the whole lifecycle: register,
+	// update, fetch
+	if err := s.putNewUser("alice", "did:key:zAlice"); err != nil {
+		t.Fatal(err)
+	}
+	root, carb := testUserCar(t, "alice", 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.getUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != root {
+		t.Fatalf("expected root %s after update, got %s", root, got)
+	}
+
+	// and its probe and listing handlers work without extra wiring
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := s.handleReady(e.NewContext(httptest.NewRequest("GET", "/ready", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 200 {
+		t.Fatalf("defaulted server should be ready, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := s.handleListUsers(e.NewContext(httptest.NewRequest("GET", "/users", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	var page struct {
+		Users []userEntry `json:"users"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Users) != 1 || page.Users[0].Name != "alice" {
+		t.Fatalf("unexpected user listing: %+v", page.Users)
+	}
+}
+
+func TestNewServerHonorsOverrides(t *testing.T) {
+	s, err := NewServer(Config{MaxGraphDepth: 3, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MaxGraphDepth != 3 || !s.ReadOnly {
+		t.Fatalf("overrides not applied: depth=%d readonly=%v", s.MaxGraphDepth, s.ReadOnly)
+	}
+}
+
+func TestNewServerRejectsBadPolicies(t *testing.T) {
+	if _, err := NewServer(Config{StorePolicy: "hope"}); err == nil {
+		t.Fatal("expected an error for an unknown store policy")
+	}
+	if _, err := NewServer(Config{CarOrderPolicy: "shuffle"}); err == nil {
+		t.Fatal("expected an error for an unknown car order policy")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	car "github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+)
+
+func TestDatadirSurvivesReopen(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+
+	ds, err := openDatastore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s1 := testServer(t)
+	s1.Blockstore = blockstore.NewBlockstore(ds)
+	s1.Roots = NewDsRootStore(ds)
+
+	root, carb := testUserCar(t, "alice", 0)
+	if err := s1.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen the same directory as a new process would
+	ds, err = openDatastore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	s2 := testServer(t)
+	s2.Blockstore = blockstore.NewBlockstore(ds)
+	s2.Roots = NewDsRootStore(ds)
+	if err := s2.loadPersistedUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s2.handleGetUser(c); err != nil {
+		t.Fatal(err)
+	}
+
+	cr, err := car.NewCarReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Header.Roots) != 1 || cr.Header.Roots[0] != root {
+		t.Fatalf("expected the original root after reopen, got %v", cr.Header.Roots)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// defaultSunsetDate is when the legacy routes go away unless the
+// operator configures otherwise.
+const defaultSunsetDate = "Thu, 31 Dec 2026 00:00:00 GMT"
+
+func (s *Server) sunsetDate() string {
+	if s.SunsetDate != "" {
+		return s.SunsetDate
+	}
+	return defaultSunsetDate
+}
+
+// deprecated marks a legacy route: responses carry Deprecation and
+// Sunset headers and each hit is counted so operators can watch the
+// migration away from it.
+func (s *Server) deprecated(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		h := c.Response().Header()
+		h.Set("Deprecation", "true")
+		h.Set("Sunset", s.sunsetDate())
+		s.countLegacyHit(c.Path())
+		return next(c)
+	}
+}
+
+func (s *Server) countLegacyHit(route string) {
+	s.legacyLk.Lock()
+	defer s.legacyLk.Unlock()
+	if s.legacyHits == nil {
+		s.legacyHits = make(map[string]int64)
+	}
+	s.legacyHits[route]++
+}
+
+// legacyHitCounts snapshots the per-route counters for /admin/info.
+func (s *Server) legacyHitCounts() map[string]int64 {
+	s.legacyLk.Lock()
+	defer s.legacyLk.Unlock()
+	out := make(map[string]int64, len(s.legacyHits))
+	for k, v := range s.legacyHits {
+		out[k] = v
+	}
+	return out
+}
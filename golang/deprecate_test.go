@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestDeprecatedRouteHeaders(t *testing.T) {
+	s := testServer(t)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("POST", "/register/alt", nil), rec)
+	c.SetPath("/register/alt")
+
+	// the legacy route signals its retirement
+	_ = s.deprecated(s.handleRegisterUserAlt)(c)
+	if rec.Header().Get("Deprecation") != "true" {
+		t.Fatal("legacy route should carry a Deprecation header")
+	}
+	if rec.Header().Get("Sunset") != defaultSunsetDate {
+		t.Fatalf("unexpected Sunset header: %q", rec.Header().Get("Sunset"))
+	}
+	if got := s.legacyHitCounts()["/register/alt"]; got != 1 {
+		t.Fatalf("expected 1 legacy hit, have %d", got)
+	}
+
+	// a current route carries neither header
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+	if err := s.handleRoot(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("Deprecation") != "" || rec.Header().Get("Sunset") != "" {
+		t.Fatal("current routes must not be marked deprecated")
+	}
+}
+
+func TestSunsetDateConfigurable(t *testing.T) {
+	s := testServer(t)
+	s.SunsetDate = "Mon, 01 Jun 2026 00:00:00 GMT"
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("POST", "/register/alt", nil), rec)
+	_ = s.deprecated(s.handleRegisterUserAlt)(c)
+	if rec.Header().Get("Sunset") != s.SunsetDate {
+		t.Fatalf("configured sunset not honored: %q", rec.Header().Get("Sunset"))
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serverVersion is bumped by hand on notable prototype milestones.
+const serverVersion = "0.0.1-dev"
+
+// handleRoot gives anyone hitting the bare base url a small json
+// descriptor instead of echo's default html 404.
+func (s *Server) handleRoot(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"did":     s.serverDid(),
+		"version": serverVersion,
+		"links": map[string]string{
+			"describe": "/describe",
+			"did":      "/.well-known/did.json",
+			"openapi":  "/openapi.json",
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRootDescriptor(t *testing.T) {
+	s := testServer(t)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+
+	if err := s.handleRoot(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var body struct {
+		Did     string            `json:"did"`
+		Version string            `json:"version"`
+		Links   map[string]string `json:"links"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Did != TwitterDid {
+		t.Fatalf("descriptor did = %q", body.Did)
+	}
+	if body.Version == "" {
+		t.Fatal("descriptor should carry a version")
+	}
+	if body.Links["describe"] == "" {
+		t.Fatal("descriptor should link to /describe")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DidResolver caches resolved did documents. For did:web users the
+// upstream document can change out from under us (key rotation), so the
+// cache supports point invalidation driven by a webhook.
+type DidResolver struct {
+	lk    sync.Mutex
+	cache map[string]interface{}
+
+	// Fetch does the actual resolution when the cache misses.
+	Fetch func(ctx context.Context, did string) (interface{}, error)
+}
+
+func NewDidResolver(fetch func(ctx context.Context, did string) (interface{}, error)) *DidResolver {
+	return &DidResolver{
+		cache: make(map[string]interface{}),
+		Fetch: fetch,
+	}
+}
+
+func (r *DidResolver) Resolve(ctx context.Context, did string) (interface{}, error) {
+	r.lk.Lock()
+	doc, ok := r.cache[did]
+	r.lk.Unlock()
+	if ok {
+		return doc, nil
+	}
+
+	doc, err := r.Fetch(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lk.Lock()
+	r.cache[did] = doc
+	r.lk.Unlock()
+	return doc, nil
+}
+
+// Invalidate evicts a single did from the cache, reporting whether it
+// was cached.
+func (r *DidResolver) Invalidate(did string) bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	_, ok := r.cache[did]
+	delete(r.cache, did)
+	return ok
+}
+
+type invalidateBody struct {
+	Did string `json:"did"`
+}
+
+// handleDidInvalidate lets upstream operators tell us a did document
+// rotated so the next resolution re-fetches it.
+func (s *Server) handleDidInvalidate(c echo.Context) error {
+	var body invalidateBody
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid body")
+	}
+	if body.Did == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing did")
+	}
+
+	evicted := s.Resolver.Invalidate(body.Did)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"did":     body.Did,
+		"evicted": evicted,
+	})
+}
+
+// defaultDidFetch is a placeholder resolver, the only identity this
+// prototype truly knows about is its own.
+func defaultDidFetch(ctx context.Context, did string) (interface{}, error) {
+	if did == TwitterDid {
+		return map[string]string{"id": did}, nil
+	}
+	return nil, fmt.Errorf("cannot resolve did: %s", did)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestDidCacheInvalidation(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	fetches := 0
+	s.Resolver = NewDidResolver(func(ctx context.Context, did string) (interface{}, error) {
+		fetches++
+		return map[string]string{"id": did}, nil
+	})
+
+	if _, err := s.Resolver.Resolve(ctx, "did:web:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolver.Resolve(ctx, "did:web:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 1 {
+		t.Fatalf("expected cached resolution, got %d fetches", fetches)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest("POST", "/cache/did/invalidate",
+		strings.NewReader(`{"did":"did:web:example.com"}`))
+	rec := httptest.NewRecorder()
+	if err := s.handleDidInvalidate(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Resolver.Resolve(ctx, "did:web:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches != 2 {
+		t.Fatalf("expected re-fetch after invalidation, got %d fetches", fetches)
+	}
+}
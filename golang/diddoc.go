@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// didDocument is the w3c did-core shape served for the server's own
+// identity. Only the fields this prototype can actually populate are
+// present.
+type didDocument struct {
+	Context            []string             `json:"@context"`
+	Id                 string               `json:"id"`
+	VerificationMethod []verificationMethod `json:"verificationMethod"`
+	Service            []didService         `json:"service"`
+}
+
+type verificationMethod struct {
+	Id                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+type didService struct {
+	Id              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// serverDidDocument assembles the did document for a did:key did. The
+// verification method falls straight out of the identifier: a did:key
+// is its own public key, multibase-encoded after the method prefix.
+func serverDidDocument(did, endpoint string) (*didDocument, error) {
+	if _, err := parseDidKey(did); err != nil {
+		return nil, err
+	}
+	mb := strings.TrimPrefix(did, "did:key:")
+
+	return &didDocument{
+		Context: []string{
+			"https://www.w3.org/ns/did/v1",
+			"https://w3id.org/security/multikey/v1",
+		},
+		Id: did,
+		VerificationMethod: []verificationMethod{{
+			Id:                 did + "#" + mb,
+			Type:               "Multikey",
+			Controller:         did,
+			PublicKeyMultibase: mb,
+		}},
+		Service: []didService{{
+			Id:              "#atproto_pds",
+			Type:            "AtprotoPersonalDataServer",
+			ServiceEndpoint: endpoint,
+		}},
+	}, nil
+}
+
+// handleGetDid serves the server's own did document at the usual
+// well-known location, with the service endpoint pointing back at
+// whatever host the client reached us on.
+func (s *Server) handleGetDid(c echo.Context) error {
+	endpoint := c.Scheme() + "://" + c.Request().Host
+	doc, err := serverDidDocument(s.serverDid(), endpoint)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, doc)
+}
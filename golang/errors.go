@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Sentinel errors for the failure categories handlers hit everywhere.
+// Wrap them with fmt.Errorf("%w: ...") so the http error handler can
+// classify without string matching.
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrConflict     = errors.New("conflict")
+
+	// ErrIncompleteDAG means an update's dag links to a block that is
+	// neither in the uploaded car nor already held by the server.
+	ErrIncompleteDAG = errors.New("incomplete dag")
+)
+
+// httpErrorHandler renders every error as {"error": category,
+// "message": detail} with the right status, instead of echo's default
+// mixed bag of shapes.
+func httpErrorHandler(err error, c echo.Context) {
+	code := http.StatusInternalServerError
+	msg := err.Error()
+
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrUnauthorized):
+		code = http.StatusUnauthorized
+	case errors.Is(err, ErrConflict):
+		code = http.StatusConflict
+	case errors.Is(err, ErrIncompleteDAG):
+		code = http.StatusBadRequest
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code = he.Code
+		msg = fmt.Sprint(he.Message)
+	}
+
+	if c.Response().Committed {
+		return
+	}
+	c.JSON(code, map[string]string{
+		"error":   errorCategory(code),
+		"message": msg,
+	})
+}
+
+// errorCategory names a status code for the json error body.
+func errorCategory(code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	case http.StatusInternalServerError:
+		return "internal"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	return fmt.Sprintf("http_%d", code)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHTTPErrorHandlerShapes(t *testing.T) {
+	e := echo.New()
+
+	cases := []struct {
+		err      error
+		code     int
+		category string
+	}{
+		{fmt.Errorf("%w: no such user: alice", ErrUserNotFound), 404, "not_found"},
+		{fmt.Errorf("%w: missing bearer token", ErrUnauthorized), 401, "unauthorized"},
+		{fmt.Errorf("%w: user already registered: bob", ErrConflict), 409, "conflict"},
+		{echo.NewHTTPError(400, "malformed car"), 400, "bad_request"},
+		{errors.New("disk fell over"), 500, "internal"},
+	}
+
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+
+		httpErrorHandler(tc.err, c)
+
+		if rec.Code != tc.code {
+			t.Fatalf("%v: status %d, want %d", tc.err, rec.Code, tc.code)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%v: body is not json: %v", tc.err, err)
+		}
+		if body["error"] != tc.category {
+			t.Fatalf("%v: category %q, want %q", tc.err, body["error"], tc.category)
+		}
+		if body["message"] == "" {
+			t.Fatalf("%v: empty message", tc.err)
+		}
+	}
+}
+
+func TestSentinelsFlowFromHandlers(t *testing.T) {
+	s := testServer(t)
+
+	// getUser wraps its miss in ErrUserNotFound
+	_, err := s.getUser("nobody")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("getUser miss should wrap ErrUserNotFound, got %v", err)
+	}
+
+	// duplicate registration is a conflict
+	if err := s.putNewUser("alice", "did:key:zA"); err != nil {
+		t.Fatal(err)
+	}
+	err = s.putNewUser("alice", "did:key:zB")
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("duplicate registration should wrap ErrConflict, got %v", err)
+	}
+
+	// a well-formed update without a token is unauthorized
+	_, carb := testUserCar(t, "carol", 0)
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest("POST", "/update", bytes.NewReader(carb)), httptest.NewRecorder())
+	err = s.handleUserUpdate(c)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("tokenless update should wrap ErrUnauthorized, got %v", err)
+	}
+}
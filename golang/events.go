@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+// Event describes a committed root update, for delivery to firehose and
+// webhook subscribers.
+type Event struct {
+	Seq  int64   `json:"seq"`
+	User string  `json:"user"`
+	Root cid.Cid `json:"root"`
+
+	// Collections names which collections changed in this commit, so
+	// subscribers can filter without fetching the roots themselves.
+	Collections []string `json:"collections,omitempty"`
+
+	// Blocks lists the cids this commit shipped, so an indexer can fetch
+	// exactly what changed instead of re-walking the whole repo.
+	Blocks []cid.Cid `json:"blocks,omitempty"`
+}
+
+type eventSub struct {
+	ch chan *Event
+}
+
+// EventBus fans committed updates out to subscribers. Buffers are
+// bounded and publishing never blocks: a slow subscriber either loses
+// events (drop mode, counted) or surfaces saturation so the caller can
+// shed load with a 503 (backpressure mode).
+type EventBus struct {
+	lk   sync.Mutex
+	subs []*eventSub
+
+	// BufferSize bounds each subscriber's channel.
+	BufferSize int
+	// DropOnFull selects drop mode instead of backpressure mode.
+	DropOnFull bool
+	// ReplaySize bounds how many recent events are kept for cursor
+	// resumption.
+	ReplaySize int
+	// MaxSubscribers caps concurrent subscribers, zero means unlimited.
+	MaxSubscribers int
+
+	replay  []*Event
+	evicted int64
+	dropped int64
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		BufferSize: 256,
+		DropOnFull: true,
+		ReplaySize: 1024,
+	}
+}
+
+// ErrTooManySubscribers refuses subscriptions past the configured cap.
+var ErrTooManySubscribers = echo.NewHTTPError(http.StatusServiceUnavailable,
+	"subscriber limit reached, retry later")
+
+func (b *EventBus) Subscribe() (*eventSub, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if b.MaxSubscribers > 0 && len(b.subs) >= b.MaxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	sub := &eventSub{ch: make(chan *Event, b.BufferSize)}
+	b.subs = append(b.subs, sub)
+	return sub, nil
+}
+
+// SubscriberCount reports how many subscribers are currently attached,
+// for metrics and capacity decisions.
+func (b *EventBus) SubscriberCount() int {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return len(b.subs)
+}
+
+func (b *EventBus) Unsubscribe(sub *eventSub) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	for i, s := range b.subs {
+		if s == sub {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			close(sub.ch)
+			return
+		}
+	}
+}
+
+// SubscribeSince is like Subscribe but first returns, in order, any
+// retained events with a sequence number past the given cursor so a
+// late subscriber can catch up. Pass a negative cursor for live-only.
+// The bool reports whether the cursor predates the replay buffer, in
+// which case events between the cursor and the oldest retained one are
+// gone for good and the replay is incomplete.
+func (b *EventBus) SubscribeSince(cursor int64) ([]*Event, bool, *eventSub, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if b.MaxSubscribers > 0 && len(b.subs) >= b.MaxSubscribers {
+		return nil, false, nil, ErrTooManySubscribers
+	}
+
+	var missed []*Event
+	tooOld := false
+	if cursor >= 0 {
+		tooOld = cursor < b.evicted
+		for _, ev := range b.replay {
+			if ev.Seq > cursor {
+				missed = append(missed, ev)
+			}
+		}
+	}
+
+	sub := &eventSub{ch: make(chan *Event, b.BufferSize)}
+	b.subs = append(b.subs, sub)
+	return missed, tooOld, sub, nil
+}
+
+// Publish delivers ev to every subscriber without blocking. In drop
+// mode events for full subscribers are counted and discarded, in
+// backpressure mode the return value reports whether anyone was full.
+func (b *EventBus) Publish(ev *Event) bool {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.replay = append(b.replay, ev)
+	if b.ReplaySize > 0 && len(b.replay) > b.ReplaySize {
+		cut := len(b.replay) - b.ReplaySize
+		b.evicted = b.replay[cut-1].Seq
+		b.replay = append([]*Event{}, b.replay[cut:]...)
+	}
+
+	ok := true
+	for _, sub := range b.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			if b.DropOnFull {
+				atomic.AddInt64(&b.dropped, 1)
+			} else {
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// Saturated reports whether any subscriber's buffer is currently full.
+func (b *EventBus) Saturated() bool {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	for _, sub := range b.subs {
+		if len(sub.ch) == cap(sub.ch) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dropped reports how many events drop mode has discarded.
+func (b *EventBus) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestEventBusDropMode(t *testing.T) {
+	b := NewEventBus()
+	b.BufferSize = 1
+
+	// a subscriber that never reads
+	if _, err := b.Subscribe(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Publish(&Event{User: "alice"})
+	b.Publish(&Event{User: "alice"})
+
+	if b.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped event, got %d", b.Dropped())
+	}
+}
+
+func TestEventBusBackpressureMode(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.Events.BufferSize = 1
+	s.Events.DropOnFull = false
+
+	// stall a subscriber and fill its buffer
+	if _, err := s.Events.Subscribe(); err != nil {
+		t.Fatal(err)
+	}
+	s.Events.Publish(&Event{User: "warmup"})
+
+	if !s.Events.Saturated() {
+		t.Fatal("bus should be saturated")
+	}
+
+	_, carb := testUserCar(t, "alice", 0)
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if err == nil {
+		t.Fatal("expected 503 while the bus is saturated")
+	}
+}
+
+func TestEventNamesChangedCollections(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	sub, err := s.Events.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Events.Unsubscribe(sub)
+
+	// first commit: everything counts as changed
+	_, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	ev := <-sub.ch
+	if len(ev.Collections) != 1 || ev.Collections[0] != "posts" {
+		t.Fatalf("first commit should change posts, got %v", ev.Collections)
+	}
+
+	// second commit reuses the same (empty) posts root, so nothing in
+	// the posts collection changed even though the root did
+	_, carb = testUserCar(t, "alice", 7)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	ev = <-sub.ch
+	if len(ev.Collections) != 0 {
+		t.Fatalf("unchanged posts root should not be reported, got %v", ev.Collections)
+	}
+}
+
+func TestSubscriberCap(t *testing.T) {
+	b := NewEventBus()
+	b.MaxSubscribers = 2
+
+	if _, err := b.Subscribe(); err != nil {
+		t.Fatal(err)
+	}
+	sub2, err := b.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.SubscriberCount() != 2 {
+		t.Fatalf("expected 2 subscribers, got %d", b.SubscriberCount())
+	}
+
+	if _, err := b.Subscribe(); err == nil {
+		t.Fatal("expected the third subscriber to be refused")
+	}
+	if _, _, _, err := b.SubscribeSince(0); err == nil {
+		t.Fatal("cursor subscriptions respect the cap too")
+	}
+
+	// room opens up again after an unsubscribe
+	b.Unsubscribe(sub2)
+	if _, err := b.Subscribe(); err != nil {
+		t.Fatalf("expected a slot after unsubscribe: %s", err)
+	}
+}
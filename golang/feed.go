@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// getUserObj fetches and decodes a user's current root object,
+// unwrapping a SignedRoot when the committed root carries one.
+func (s *Server) getUserObj(ctx context.Context, id string) (*User, error) {
+	root, err := s.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u, _, err := s.decodeUserRoot(ctx, s.readStore(), root)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+type hamtEntry struct {
+	Key string  `json:"key"`
+	Cid cid.Cid `json:"cid"`
+}
+
+// listError reports one entry that could not be decoded during a
+// listing walk.
+type listError struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// collectHamtEntries loads every key/cid pair under root, sorted by
+// key. A single corrupt value shouldnt take a whole listing down, so by
+// default bad entries come back separately alongside the good ones;
+// ListFailFast turns them into a hard error instead.
+func (s *Server) collectHamtEntries(ctx context.Context, root cid.Cid) ([]hamtEntry, []listError, error) {
+	cst := cbornode.NewCborStore(s.readStore())
+	nd, err := hamt.LoadNode(ctx, cst, root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []hamtEntry
+	var broken []listError
+	if err := nd.ForEach(ctx, func(k string, val *cbg.Deferred) error {
+		var cc cbg.CborCid
+		if err := cc.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
+			if s.ListFailFast {
+				return fmt.Errorf("hamt value for %q was not a cid: %w", k, err)
+			}
+			broken = append(broken, listError{Key: k, Error: err.Error()})
+			return nil
+		}
+		entries = append(entries, hamtEntry{Key: k, Cid: cid.Cid(cc)})
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, broken, nil
+}
+
+// handleListEntries exposes the posts hamt as a flat key/value listing,
+// streamed as ndjson. This is lower level than the decoded posts view,
+// meant for tooling that wants the raw keys and cids.
+func (s *Server) handleListEntries(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	u, err := s.getUserObj(ctx, c.Param("id"))
+	if err != nil {
+		return s.respondQuarantine(c, err)
+	}
+
+	limit, cursor, err := s.parsePage(c)
+	if err != nil {
+		return err
+	}
+
+	entries, broken, err := s.collectHamtEntries(ctx, u.PostsRoot)
+	if err != nil {
+		return s.respondQuarantine(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	c.Response().WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(c.Response())
+
+	var lastKey string
+	sent := 0
+	for _, e := range entries {
+		if cursor != "" && e.Key <= cursor {
+			continue
+		}
+		if sent == limit {
+			// more to come, tell the client where to pick up
+			return enc.Encode(map[string]string{"cursor": lastKey})
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		lastKey = e.Key
+		sent++
+	}
+
+	if len(broken) > 0 {
+		return enc.Encode(map[string]interface{}{"errors": broken})
+	}
+	return nil
+}
+
+// postEntry is one decoded row of the posts view.
+type postEntry struct {
+	Key  string      `json:"key"`
+	Cid  string      `json:"cid"`
+	Post interface{} `json:"post"`
+}
+
+// handleListPosts walks the posts hamt server-side and returns the
+// records decoded to json, for clients that dont want to implement
+// car/ipld parsing. Keys page lexicographically, exactly like the raw
+// /entries listing underneath it.
+func (s *Server) handleListPosts(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	u, err := s.getUserObj(ctx, c.Param("id"))
+	if err != nil {
+		return s.respondQuarantine(c, err)
+	}
+
+	limit, cursor, err := s.parsePage(c)
+	if err != nil {
+		return err
+	}
+
+	entries, broken, err := s.collectHamtEntries(ctx, u.PostsRoot)
+	if err != nil {
+		return s.respondQuarantine(c, err)
+	}
+
+	posts := []postEntry{}
+	var lastKey string
+	for _, e := range entries {
+		if cursor != "" && e.Key <= cursor {
+			continue
+		}
+		if len(posts) == limit {
+			break
+		}
+
+		// a quarantined record tombstones the listing rather than
+		// landing in the errors sidecar like ordinary corruption
+		if err := s.guardQuarantine(e.Cid); err != nil {
+			return s.respondQuarantine(c, err)
+		}
+
+		val, err := s.decodeRecordJSON(ctx, e.Cid)
+		if err != nil {
+			if s.ListFailFast {
+				return err
+			}
+			broken = append(broken, listError{Key: e.Key, Error: err.Error()})
+			continue
+		}
+
+		posts = append(posts, postEntry{Key: e.Key, Cid: s.renderCid(e.Cid), Post: val})
+		lastKey = e.Key
+	}
+
+	out := map[string]interface{}{
+		"user":  u.Name,
+		"posts": posts,
+	}
+	if len(posts) == limit && limit > 0 {
+		out["cursor"] = lastKey
+	}
+	if len(broken) > 0 {
+		out["errors"] = broken
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// decodeRecordJSON fetches one record block and turns it into a value
+// the json encoder can render, with numbers normalized the same way the
+// gateway does.
+func (s *Server) decodeRecordJSON(ctx context.Context, c cid.Cid) (interface{}, error) {
+	if c.Prefix().Codec != cid.DagCBOR {
+		return nil, fmt.Errorf("record %s is not dag-cbor", c)
+	}
+
+	blk, err := s.Blockstore.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	nd, err := cbornode.DecodeBlock(blk)
+	if err != nil {
+		return nil, err
+	}
+	val, _, err := nd.(*cbornode.Node).Resolve(nil)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeNumbers(val, s.StrictNumbers)
+}
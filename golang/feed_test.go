@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	blockservice "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	merkledag "github.com/ipfs/go-merkledag"
+	car "github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// putTestUser stores a user with a posts hamt holding the given keys
+// directly in the server's blockstore.
+func putTestUser(t *testing.T, s *Server, name string, keys []string) {
+	t.Helper()
+	ctx := context.TODO()
+
+	cst := cbornode.NewCborStore(s.Blockstore)
+	nd := hamt.NewNode(cst)
+
+	val, err := cbornode.WrapObject(map[string]string{"stub": "post"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range keys {
+		cc := cbg.CborCid(val.Cid())
+		if err := nd.Set(ctx, k, &cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		Name:      name,
+		NextPost:  int64(len(keys)),
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	s.UserRoots[name] = user.Cid()
+	s.UserDids[name] = "did:key:z" + name
+}
+
+// putTestUserRecord stores a user whose posts hamt holds a single
+// record with the given shape.
+func putTestUserRecord(t *testing.T, s *Server, name string, rec interface{}) {
+	t.Helper()
+	ctx := context.TODO()
+
+	cst := cbornode.NewCborStore(s.Blockstore)
+	nd := hamt.NewNode(cst)
+
+	val, err := cbornode.WrapObject(rec, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := cbg.CborCid(val.Cid())
+	if err := nd.Set(ctx, "0", &cc); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		Name:      name,
+		NextPost:  1,
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	s.UserRoots[name] = user.Cid()
+	s.UserDids[name] = "did:key:z" + name
+}
+
+func TestListEntriesPagination(t *testing.T) {
+	s := testServer(t)
+
+	var keys []string
+	for i := 0; i < 25; i++ {
+		keys = append(keys, fmt.Sprintf("%03d", i))
+	}
+	putTestUser(t, s, "alice", keys)
+
+	e := echo.New()
+	seen := make(map[string]int)
+	cursor := ""
+
+	for page := 0; page < 10; page++ {
+		req := httptest.NewRequest("GET", "/?limit=10&cursor="+cursor, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("alice")
+
+		if err := s.handleListEntries(c); err != nil {
+			t.Fatal(err)
+		}
+
+		next := ""
+		for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+			var out map[string]string
+			if err := json.Unmarshal([]byte(line), &out); err != nil {
+				t.Fatal(err)
+			}
+			if cur, ok := out["cursor"]; ok {
+				next = cur
+				continue
+			}
+			seen[out["key"]]++
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if len(seen) != len(keys) {
+		t.Fatalf("expected %d distinct keys, saw %d", len(keys), len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("key %s appeared %d times", k, n)
+		}
+	}
+}
+
+func TestListEntriesSkipsCorruptRecords(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	cst := cbornode.NewCborStore(s.Blockstore)
+	nd := hamt.NewNode(cst)
+
+	val, err := cbornode.WrapObject(map[string]string{"stub": "post"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+
+	// nine good entries and one whose value is an int instead of a cid
+	for i := 0; i < 9; i++ {
+		cc := cbg.CborCid(val.Cid())
+		if err := nd.Set(ctx, fmt.Sprintf("%03d", i), &cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nd.Set(ctx, "bad", &cbg.Deferred{Raw: []byte{0x01}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cbornode.WrapObject(&User{
+		Name:      "alice",
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	s.UserRoots["alice"] = user.Cid()
+	s.UserDids["alice"] = "did:key:zalice"
+
+	e := echo.New()
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+
+	if err := s.handleListEntries(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var good int
+	var errFrames int
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		var out map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &out); err != nil {
+			t.Fatal(err)
+		}
+		if errs, ok := out["errors"]; ok {
+			errFrames++
+			list := errs.([]interface{})
+			if len(list) != 1 {
+				t.Fatalf("expected one error entry, got %v", errs)
+			}
+			ent := list[0].(map[string]interface{})
+			if ent["key"] != "bad" {
+				t.Fatalf("error entry named the wrong key: %v", ent)
+			}
+			continue
+		}
+		good++
+	}
+	if good != 9 {
+		t.Fatalf("expected the nine decodable entries, got %d", good)
+	}
+	if errFrames != 1 {
+		t.Fatal("expected a sidecar error frame")
+	}
+
+	// fail-fast mode keeps the old abort behavior
+	s.ListFailFast = true
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s.handleListEntries(c); err == nil {
+		t.Fatal("fail-fast listing should abort on the corrupt entry")
+	}
+}
+
+// buildDistinctPostsCar builds an update car whose posts all have
+// different text, so tests can tell them apart after a round trip.
+func buildDistinctPostsCar(t *testing.T, name string, nposts int) (cid.Cid, []byte) {
+	t.Helper()
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	nd := hamt.NewNode(cst)
+
+	for i := 0; i < nposts; i++ {
+		val, err := cbornode.WrapObject(map[string]string{"text": fmt.Sprintf("post %d", i)}, mh.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(ctx, val); err != nil {
+			t.Fatal(err)
+		}
+		cc := cbg.CborCid(val.Cid())
+		if err := nd.Set(ctx, fmt.Sprintf("%03d", i), &cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		Name:      name,
+		NextPost:  int64(nposts),
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{user.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+	return user.Cid(), buf.Bytes()
+}
+
+func TestListPostsJSON(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	_, carb := buildDistinctPostsCar(t, "alice", 7)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	type page struct {
+		User   string      `json:"user"`
+		Posts  []postEntry `json:"posts"`
+		Cursor string      `json:"cursor"`
+	}
+	fetch := func(query string) page {
+		req := httptest.NewRequest("GET", "/"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("alice")
+		if err := s.handleListPosts(c); err != nil {
+			t.Fatal(err)
+		}
+		var out page
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	out := fetch("")
+	if out.User != "alice" {
+		t.Fatalf("wrong user in response: %q", out.User)
+	}
+	if len(out.Posts) != 7 {
+		t.Fatalf("expected 7 posts, got %d", len(out.Posts))
+	}
+	for i, p := range out.Posts {
+		if p.Key != fmt.Sprintf("%03d", i) {
+			t.Fatalf("posts out of order: entry %d has key %q", i, p.Key)
+		}
+		rec, ok := p.Post.(map[string]interface{})
+		if !ok {
+			t.Fatalf("post %d did not decode to an object: %v", i, p.Post)
+		}
+		if rec["text"] != fmt.Sprintf("post %d", i) {
+			t.Fatalf("post %d has wrong text: %v", i, rec["text"])
+		}
+		if p.Cid == "" {
+			t.Fatalf("post %d is missing its cid", i)
+		}
+	}
+	if out.Cursor != "" {
+		t.Fatal("full listing should not return a cursor")
+	}
+
+	// paging through with a small limit sees everything exactly once
+	var paged []postEntry
+	cursor := ""
+	for {
+		out := fetch("?limit=3&cursor=" + cursor)
+		paged = append(paged, out.Posts...)
+		if out.Cursor == "" {
+			break
+		}
+		cursor = out.Cursor
+	}
+	if len(paged) != 7 {
+		t.Fatalf("paged listing returned %d posts", len(paged))
+	}
+	for i, p := range paged {
+		if p.Key != fmt.Sprintf("%03d", i) {
+			t.Fatalf("paged posts out of order at %d: %q", i, p.Key)
+		}
+	}
+}
+
+func TestListPostsReportsCorruptRecords(t *testing.T) {
+	s := testServer(t)
+	putTestUserRecord(t, s, "alice", map[string]interface{}{"text": "hi"})
+
+	// point a second key at a cid the server doesnt hold
+	ctx := context.TODO()
+	u, err := s.getUserObj(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cst := cbornode.NewCborStore(s.Blockstore)
+	nd, err := hamt.LoadNode(ctx, cst, u.PostsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := cbornode.WrapObject(map[string]string{"text": "gone"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := cbg.CborCid(missing.Cid())
+	if err := nd.Set(ctx, "1", &cc); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cbornode.WrapObject(&User{Name: "alice", NextPost: 2, PostsRoot: postsRoot}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	s.UserRoots["alice"] = user.Cid()
+
+	e := echo.New()
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s.handleListPosts(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Posts  []postEntry `json:"posts"`
+		Errors []listError `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Posts) != 1 || out.Posts[0].Key != "0" {
+		t.Fatalf("expected the one readable post, got %v", out.Posts)
+	}
+	if len(out.Errors) != 1 || out.Errors[0].Key != "1" {
+		t.Fatalf("expected an error for the unreadable post, got %v", out.Errors)
+	}
+}
+
+func TestListPostsSignedRoot(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// a signed commit wraps the user object, the listing has to unwrap
+	// it rather than decode the SignedRoot as a user
+	_, carb := testSignedUserCar(t, "alice", TwitterDid)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s.handleListPosts(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		User  string      `json:"user"`
+		Posts []postEntry `json:"posts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.User != "alice" || len(out.Posts) != 0 {
+		t.Fatalf("expected an empty listing for alice, got %+v", out)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+func init() {
+	cbornode.RegisterCborType(FrameHeader{})
+	cbornode.RegisterCborType(CommitEvt{})
+	cbornode.RegisterCborType(InfoEvt{})
+}
+
+// FrameHeader is the header half of a firehose frame, matching the
+// atproto event stream framing of a cbor header followed by a cbor
+// payload.
+type FrameHeader struct {
+	Op   int64
+	Type string
+}
+
+// CommitEvt is the payload of a "#commit" frame.
+type CommitEvt struct {
+	Seq    int64
+	Did    string
+	Root   cid.Cid
+	Blocks []cid.Cid
+}
+
+// InfoEvt is the payload of an "#info" control frame.
+type InfoEvt struct {
+	Name string
+}
+
+// infoOutdatedCursor tells a subscriber that its cursor predates the
+// replay buffer, so some events between the cursor and the first frame
+// it receives were lost.
+const infoOutdatedCursor = "OutdatedCursor"
+
+// encodeFrame concatenates a frame header of the given type with an
+// already-encodable payload: one cbor object immediately followed by
+// another, the way the atproto event stream frames them.
+func encodeFrame(typ string, payload interface{}) ([]byte, error) {
+	hdr, err := cbornode.DumpObject(&FrameHeader{Op: 1, Type: typ})
+	if err != nil {
+		return nil, err
+	}
+	body, err := cbornode.DumpObject(payload)
+	if err != nil {
+		return nil, err
+	}
+	return append(hdr, body...), nil
+}
+
+// encodeCommitFrame serializes an event as a complete "#commit" frame.
+func encodeCommitFrame(ev *Event, did string) ([]byte, error) {
+	return encodeFrame("#commit", &CommitEvt{
+		Seq:    ev.Seq,
+		Did:    did,
+		Root:   ev.Root,
+		Blocks: ev.Blocks,
+	})
+}
+
+// sendFrame delivers one frame as a single binary websocket message.
+// Existing atproto consumers expect header and payload back to back in
+// the same message, splitting them (or sending text frames) breaks
+// their decoders.
+func sendFrame(ws *websocket.Conn, frame []byte) error {
+	return websocket.Message.Send(ws, frame)
+}
+
+// writeInfoFrame sends an "#info" control frame.
+func writeInfoFrame(ws *websocket.Conn, name string) error {
+	frame, err := encodeFrame("#info", &InfoEvt{Name: name})
+	if err != nil {
+		return err
+	}
+	return sendFrame(ws, frame)
+}
+
+// writeCommitFrame sends a complete commit frame.
+func writeCommitFrame(ws *websocket.Conn, ev *Event, did string) error {
+	frame, err := encodeCommitFrame(ev, did)
+	if err != nil {
+		return err
+	}
+	return sendFrame(ws, frame)
+}
+
+// handleSubscribe streams commit frames over a websocket, one binary
+// message per committed update. A numeric cursor
+// query param replays retained events past that sequence before live
+// delivery starts, the same cursor scheme /log/:seq uses. Slow
+// consumers are handled by the event bus: with the default drop mode a
+// subscriber that cant keep up silently loses events rather than
+// stalling commits.
+func (s *Server) handleSubscribe(c echo.Context) error {
+	cursor := int64(-1)
+	if cs := c.QueryParam("cursor"); cs != "" {
+		n, err := strconv.ParseInt(cs, 10, 64)
+		if err != nil || n < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+		}
+		cursor = n
+	}
+
+	missed, tooOld, sub, err := s.Events.SubscribeSince(cursor)
+	if err != nil {
+		return err
+	}
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		defer s.Events.Unsubscribe(sub)
+
+		// a cursor from before the replay buffer gets a control frame up
+		// front, then the stream resumes from the oldest retained event
+		if tooOld {
+			if err := writeInfoFrame(ws, infoOutdatedCursor); err != nil {
+				return
+			}
+		}
+
+		for _, ev := range missed {
+			if err := s.writeEventFrame(ws, ev); err != nil {
+				return
+			}
+		}
+
+		// notice client disconnects even while no events are flowing
+		done := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, ws)
+			close(done)
+		}()
+
+		for {
+			select {
+			case ev, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				if err := s.writeEventFrame(ws, ev); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+func (s *Server) writeEventFrame(ws *websocket.Conn, ev *Event) error {
+	did, _ := s.lookupDid(ev.User)
+	return writeCommitFrame(ws, ev, did)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// splitFrame separates the header from the payload of a single-message
+// frame. The header encoding is deterministic per frame type, so the
+// header must be a prefix of the frame bytes.
+func splitFrame(t *testing.T, frame []byte) (FrameHeader, []byte) {
+	t.Helper()
+	for _, typ := range []string{"#commit", "#info"} {
+		hdr, err := cbornode.DumpObject(&FrameHeader{Op: 1, Type: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(frame, hdr) {
+			return FrameHeader{Op: 1, Type: typ}, frame[len(hdr):]
+		}
+	}
+	t.Fatalf("frame does not start with a known header: %x", frame)
+	return FrameHeader{}, nil
+}
+
+func TestCommitFrameEncoding(t *testing.T) {
+	frame, err := encodeCommitFrame(&Event{Seq: 7, User: "alice"}, "did:key:zAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fh, payload := splitFrame(t, frame)
+	if fh.Op != 1 || fh.Type != "#commit" {
+		t.Fatalf("bad frame header: %+v", fh)
+	}
+
+	var evt CommitEvt
+	if err := cbornode.DecodeInto(payload, &evt); err != nil {
+		t.Fatal(err)
+	}
+	if evt.Seq != 7 || evt.Did != "did:key:zAlice" {
+		t.Fatalf("bad commit payload: %+v", evt)
+	}
+}
+
+func TestCursorReplay(t *testing.T) {
+	b := NewEventBus()
+
+	for i := int64(0); i < 10; i++ {
+		b.Publish(&Event{Seq: i, User: "alice"})
+	}
+
+	missed, tooOld, sub, err := b.SubscribeSince(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unsubscribe(sub)
+
+	if tooOld {
+		t.Fatal("an in-buffer cursor should not be reported as too old")
+	}
+	if len(missed) != 5 {
+		t.Fatalf("expected 5 replayed events, got %d", len(missed))
+	}
+	for i, ev := range missed {
+		if ev.Seq != int64(5+i) {
+			t.Fatalf("replay out of order: got seq %d at index %d", ev.Seq, i)
+		}
+	}
+
+	// live events still flow after the replay
+	b.Publish(&Event{Seq: 10, User: "alice"})
+	if ev := <-sub.ch; ev.Seq != 10 {
+		t.Fatalf("expected live seq 10, got %d", ev.Seq)
+	}
+}
+
+func TestSubscribeWebsocket(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	e := echo.New()
+	e.GET("/subscribe", s.handleSubscribe)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/subscribe", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// let the subscription attach before committing, or the event could
+	// be published into thin air
+	for i := 0; s.Events.SubscriberCount() == 0; i++ {
+		if i > 500 {
+			t.Fatal("subscriber never attached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	root, carb := testUserCar(t, "alice", 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	// each frame arrives as one binary message, header immediately
+	// followed by payload
+	var frame []byte
+	if err := websocket.Message.Receive(ws, &frame); err != nil {
+		t.Fatal(err)
+	}
+
+	fh, payload := splitFrame(t, frame)
+	if fh.Op != 1 || fh.Type != "#commit" {
+		t.Fatalf("bad frame header: %+v", fh)
+	}
+
+	var evt CommitEvt
+	if err := cbornode.DecodeInto(payload, &evt); err != nil {
+		t.Fatal(err)
+	}
+	if evt.Root != root {
+		t.Fatalf("event root %s does not match the committed root %s", evt.Root, root)
+	}
+	if len(evt.Blocks) == 0 {
+		t.Fatal("event should carry the changed block cids")
+	}
+	var hasRoot bool
+	for _, b := range evt.Blocks {
+		if b == root {
+			hasRoot = true
+		}
+	}
+	if !hasRoot {
+		t.Fatal("changed blocks should include the new root")
+	}
+
+	// the subscriber goes away with the connection
+	ws.Close()
+	for i := 0; s.Events.SubscriberCount() != 0; i++ {
+		if i > 500 {
+			t.Fatal("subscriber never detached after close")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCursorReplayTooOld(t *testing.T) {
+	b := NewEventBus()
+	b.ReplaySize = 4
+
+	for i := int64(0); i < 10; i++ {
+		b.Publish(&Event{Seq: i, User: "alice"})
+	}
+
+	// seqs 0-5 are gone, only 6-9 remain
+	missed, tooOld, sub, err := b.SubscribeSince(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unsubscribe(sub)
+
+	if !tooOld {
+		t.Fatal("a cursor before the buffer should be reported as too old")
+	}
+	if len(missed) != 4 {
+		t.Fatalf("expected the 4 retained events, got %d", len(missed))
+	}
+	if missed[0].Seq != 6 {
+		t.Fatalf("replay should start at the oldest retained seq, got %d", missed[0].Seq)
+	}
+
+	// the newest retained seq is a fine cursor
+	_, tooOld, sub2, err := b.SubscribeSince(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unsubscribe(sub2)
+	if tooOld {
+		t.Fatal("a current cursor should not be reported as too old")
+	}
+}
+
+func TestSubscribeOutdatedCursorFrame(t *testing.T) {
+	s := testServer(t)
+	s.Events.ReplaySize = 2
+
+	for i := int64(0); i < 6; i++ {
+		s.Events.Publish(&Event{Seq: i, User: "alice"})
+	}
+
+	e := echo.New()
+	e.GET("/subscribe", s.handleSubscribe)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/subscribe?cursor=0", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	readFrame := func() (FrameHeader, []byte) {
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatal(err)
+		}
+		return splitFrame(t, frame)
+	}
+
+	fh, payload := readFrame()
+	if fh.Type != "#info" {
+		t.Fatalf("expected the #info control frame first, got %q", fh.Type)
+	}
+	var info InfoEvt
+	if err := cbornode.DecodeInto(payload, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != infoOutdatedCursor {
+		t.Fatalf("wrong info name: %q", info.Name)
+	}
+
+	// the retained tail still replays after the warning
+	for want := int64(4); want <= 5; want++ {
+		fh, payload := readFrame()
+		if fh.Type != "#commit" {
+			t.Fatalf("expected a commit frame, got %q", fh.Type)
+		}
+		var evt CommitEvt
+		if err := cbornode.DecodeInto(payload, &evt); err != nil {
+			t.Fatal(err)
+		}
+		if evt.Seq != want {
+			t.Fatalf("replayed seq %d, want %d", evt.Seq, want)
+		}
+	}
+}
+
+func TestSubscribeCursorReplayOverWebsocket(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	_, carb := testUserCar(t, "alice", 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	root, carb2 := testUserCar(t, "alice", 2)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb2)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.GET("/subscribe", s.handleSubscribe)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	// connect after the fact, cursored past the first commit, and get
+	// the second one back
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/subscribe?cursor=0", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var frame []byte
+	if err := websocket.Message.Receive(ws, &frame); err != nil {
+		t.Fatal(err)
+	}
+
+	fh, payload := splitFrame(t, frame)
+	if fh.Type != "#commit" {
+		t.Fatalf("expected a replayed commit frame, got %q", fh.Type)
+	}
+	var evt CommitEvt
+	if err := cbornode.DecodeInto(payload, &evt); err != nil {
+		t.Fatal(err)
+	}
+	if evt.Root != root {
+		t.Fatalf("replayed root %s does not match the committed root %s", evt.Root, root)
+	}
+}
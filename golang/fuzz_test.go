@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func FuzzParseAndValidateCAR(f *testing.F) {
+	// seed with a valid car plus a few malformed shapes
+	if _, valid, err := buildTestUserCar("alice", 0); err == nil {
+		f.Add(valid)
+		f.Add(valid[:len(valid)/2])
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x00})
+	f.Add([]byte("not a car at all"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// any input may be rejected, none may panic
+		_, _, _ = parseAndValidateCAR(data)
+	})
+}
+
+func TestParseAndValidateCAR(t *testing.T) {
+	root, carb := testUserCar(t, "alice", 0)
+
+	got, bs, err := parseAndValidateCAR(carb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != root {
+		t.Fatalf("wrong root: %s", got)
+	}
+	if bs == nil {
+		t.Fatal("expected a populated blockstore")
+	}
+
+	if _, _, err := parseAndValidateCAR([]byte("junk")); err == nil {
+		t.Fatal("expected junk input to be rejected")
+	}
+}
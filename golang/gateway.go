@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/labstack/echo/v4"
+)
+
+// maxGatewayPathDepth bounds how many segments an /ipfs path may have so
+// a client cant make us chase an arbitrarily long traversal.
+const maxGatewayPathDepth = 32
+
+// parseCidParam decodes a cid route param. Any multibase cid.Decode
+// understands is accepted and normalized to its parsed form, so lookups
+// always key on the canonical cid. Anything else is a clear 400 rather
+// than a confusing store miss.
+func parseCidParam(v string) (cid.Cid, error) {
+	cc, err := cid.Decode(v)
+	if err != nil {
+		return cid.Undef, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid CID: %s", err))
+	}
+	return cc, nil
+}
+
+// handleIpfsGet serves gateway-style reads of stored blocks, resolving a
+// path through the dag starting at the given cid. Only blocks we already
+// hold (ie, reachable from some user's repo) get served, we never go out
+// to the network.
+func (s *Server) handleIpfsGet(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	cc, err := parseCidParam(c.Param("cid"))
+	if err != nil {
+		return err
+	}
+
+	path := strings.Trim(c.Param("*"), "/")
+	var segs []string
+	if path != "" {
+		segs = strings.Split(path, "/")
+	}
+	if len(segs) > maxGatewayPathDepth {
+		return echo.NewHTTPError(http.StatusBadRequest, "path too deep")
+	}
+
+	cur := cc
+	rest := segs
+	for {
+		if s.Quarantine.has(cur) {
+			return s.quarantineTombstone(c, cur)
+		}
+
+		blk, err := s.Blockstore.Get(ctx, cur)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("block not found: %s", cur))
+		}
+
+		if cur.Prefix().Codec == cid.Raw {
+			if len(rest) != 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "cannot traverse into a raw block")
+			}
+			return c.Blob(http.StatusOK, "application/vnd.ipld.raw", blk.RawData())
+		}
+
+		nd, err := cbornode.DecodeBlock(blk)
+		if err != nil {
+			return err
+		}
+
+		if len(rest) == 0 {
+			out, err := nd.(*cbornode.Node).MarshalJSON()
+			if err != nil {
+				return err
+			}
+			return c.JSONBlob(http.StatusOK, out)
+		}
+
+		val, remaining, err := nd.(*cbornode.Node).Resolve(rest)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("path not found: %s", err))
+		}
+
+		if lnk, ok := val.(*format.Link); ok {
+			cur = lnk.Cid
+			rest = remaining
+			continue
+		}
+
+		out, err := normalizeNumbers(val, s.StrictNumbers)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		return c.JSON(http.StatusOK, out)
+	}
+}
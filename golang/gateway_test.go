@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	"github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestGatewayPathResolution(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	inner, err := cbornode.WrapObject(map[string]interface{}{"baz": 42}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, inner); err != nil {
+		t.Fatal(err)
+	}
+
+	outer, err := cbornode.WrapObject(map[string]interface{}{
+		"foo": map[string]interface{}{"bar": inner.Cid()},
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, outer); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("cid", "*")
+	c.SetParamValues(outer.Cid().String(), "foo/bar/baz")
+
+	if err := s.handleIpfsGet(c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rec.Body.String(), "42") {
+		t.Fatalf("expected resolved value, got %s", rec.Body.String())
+	}
+}
+
+func TestCidParamNormalization(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	nd, err := cbornode.WrapObject(map[string]interface{}{"hello": "world"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+
+	b58, err := nd.Cid().StringOfBase(multibase.Base58BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same cid in base32 and base58btc both resolve to the block
+	e := echo.New()
+	for _, enc := range []string{nd.Cid().String(), b58} {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+		c.SetParamNames("cid", "*")
+		c.SetParamValues(enc, "")
+
+		if err := s.handleIpfsGet(c); err != nil {
+			t.Fatalf("cid %q should resolve: %s", enc, err)
+		}
+		if !strings.Contains(rec.Body.String(), "world") {
+			t.Fatalf("cid %q returned %s", enc, rec.Body.String())
+		}
+	}
+
+	// a non-cid string fails clearly
+	_, err = parseCidParam("not-a-cid")
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 400 {
+		t.Fatalf("expected a 400 for garbage, got %v", err)
+	}
+}
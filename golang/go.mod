@@ -0,0 +1,24 @@
+module github.com/bluesky-social/atproto/golang
+
+go 1.21
+
+require (
+	github.com/ipfs/go-block-format v0.1.1
+	github.com/ipfs/go-blockservice v0.5.0
+	github.com/ipfs/go-cid v0.3.2
+	github.com/ipfs/go-datastore v0.6.0
+	github.com/ipfs/go-ds-badger v0.3.0
+	github.com/ipfs/go-hamt-ipld/v3 v3.1.0
+	github.com/ipfs/go-ipfs-blockstore v1.2.0
+	github.com/ipfs/go-ipld-cbor v0.0.6
+	github.com/ipfs/go-ipld-format v0.4.0
+	github.com/ipfs/go-merkledag v0.9.0
+	github.com/ipld/go-car v0.5.0
+	github.com/labstack/echo/v4 v4.10.0
+	github.com/multiformats/go-multibase v0.1.1
+	github.com/multiformats/go-multihash v0.2.1
+	github.com/qri-io/ucan v0.1.0
+	github.com/whyrusleeping/cbor-gen v0.0.0-20230126041949-52956bd4c9aa
+	golang.org/x/net v0.7.0
+	golang.org/x/text v0.7.0
+)
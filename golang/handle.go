@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeHandle canonicalizes a handle so every visual spelling of the
+// same name lands on one key: NFC first (so café composed and decomposed
+// are equal), then lowercase, then punycode for any remaining non-ascii
+// labels. Handles that mix scripts within a label are rejected outright,
+// they exist mostly for homograph impersonation.
+func normalizeHandle(handle string) (string, error) {
+	h := strings.ToLower(norm.NFC.String(handle))
+
+	if err := checkConfusableHandle(h); err != nil {
+		return "", err
+	}
+
+	if !isASCII(h) {
+		a, err := idna.Lookup.ToASCII(h)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("handle %q is not a valid idn: %s", handle, err))
+		}
+		h = a
+	}
+
+	return h, nil
+}
+
+// maxHandleLen caps handles at the dns hostname limit, which is plenty
+// for a name and keeps them usable as path segments and map keys.
+const maxHandleLen = 253
+
+// reservedHandles are path segments the router already owns; letting a
+// user register as "health" would shadow those endpoints anywhere a
+// handle appears in a url.
+var reservedHandles = map[string]bool{
+	"admin":    true,
+	"describe": true,
+	"events":   true,
+	"health":   true,
+	"log":      true,
+	"metrics":  true,
+	"ready":    true,
+	"register": true,
+	"root":     true,
+	"update":   true,
+	"user":     true,
+	"users":    true,
+}
+
+// validateHandle enforces the registration-time rules on an
+// already-normalized handle: non-empty, bounded length, a conservative
+// charset that cant escape the /user/:id route, and no names the server
+// uses for its own endpoints. Lookups skip this, only claiming a name
+// is gated.
+func validateHandle(name string) error {
+	if name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "handle must not be empty")
+	}
+	if len(name) > maxHandleLen {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("handle longer than %d bytes", maxHandleLen))
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9':
+		case r == '-' || r == '.':
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("handle contains invalid character %q", r))
+		}
+	}
+	if reservedHandles[name] {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("handle %q is reserved", name))
+	}
+	return nil
+}
+
+// checkConfusableHandle refuses labels that mix latin with cyrillic or
+// greek, the classic homograph trick ("pаypal" with a cyrillic а). Pure
+// single-script labels in any of the three are fine.
+func checkConfusableHandle(handle string) error {
+	for _, label := range strings.Split(handle, ".") {
+		var latin, cyrillic, greek bool
+		for _, r := range label {
+			switch {
+			case unicode.Is(unicode.Latin, r):
+				latin = true
+			case unicode.Is(unicode.Cyrillic, r):
+				cyrillic = true
+			case unicode.Is(unicode.Greek, r):
+				greek = true
+			}
+		}
+		if (latin && cyrillic) || (latin && greek) || (cyrillic && greek) {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("handle label %q mixes scripts", label))
+		}
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
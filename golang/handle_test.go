@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandleNormalization(t *testing.T) {
+	s := testServer(t)
+
+	// composed form registers...
+	nfc := "café.example"
+	if err := s.putNewUser(nfc, "did:key:zCafe"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ...and is stored under its punycode spelling
+	if _, ok := s.UserRoots["xn--caf-dma.example"]; !ok {
+		t.Fatalf("expected punycode key, have %v", s.UserRoots)
+	}
+
+	// the decomposed spelling (e plus combining acute) resolves to
+	// the same user
+	nfd := "café.example"
+	if _, err := s.getUser(nfd); err != nil {
+		t.Fatalf("nfd spelling should resolve: %v", err)
+	}
+
+	// and cannot be registered as a second account
+	err := s.putNewUser(nfd, "did:key:zImpostor")
+	if err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Fatalf("expected duplicate registration error, got %v", err)
+	}
+}
+
+func TestConfusableHandleRejected(t *testing.T) {
+	s := testServer(t)
+
+	// latin "paypal" with a cyrillic а in the middle
+	err := s.putNewUser("pаypal.example", "did:key:zEvil")
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a mixed-script handle, got %v", err)
+	}
+
+	// a pure cyrillic label is not confusable, just non-latin
+	if err := s.putNewUser("привет.example", "did:key:zOk"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateHandle(t *testing.T) {
+	cases := []struct {
+		name   string
+		handle string
+		ok     bool
+	}{
+		{"simple", "alice", true},
+		{"dotted", "alice.example", true},
+		{"hyphen and digits", "alice-2.example", true},
+		{"empty", "", false},
+		{"too long", strings.Repeat("a", maxHandleLen+1), false},
+		{"uppercase", "Alice", false},
+		{"path injection", "../admin", false},
+		{"embedded slash", "alice/root", false},
+		{"space", "alice smith", false},
+		{"reserved health", "health", false},
+		{"reserved metrics", "metrics", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateHandle(tc.handle)
+			if tc.ok && err != nil {
+				t.Fatalf("expected %q to be valid: %v", tc.handle, err)
+			}
+			if !tc.ok {
+				if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+					t.Fatalf("expected 400 for %q, got %v", tc.handle, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterRejectsInvalidHandle(t *testing.T) {
+	s := testServer(t)
+
+	// rejected before the maps are touched
+	if err := s.putNewUser("../admin", "did:key:zSneaky"); err == nil {
+		t.Fatal("expected path-injection handle to be rejected")
+	}
+	if s.userCount() != 0 {
+		t.Fatalf("invalid registration must not create users, have %d", s.userCount())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// handleHealth is a trivial liveness probe: it answers ok as long as
+// the process is serving requests at all. Load balancers that want to
+// know whether we can actually do work should use /ready instead.
+func (s *Server) handleHealth(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readinessProbeCid is a fixed cid we poke the backing stores with. The
+// block is not expected to exist, a clean miss is fine; only a store
+// that errors on the lookup means not-ready.
+func readinessProbeCid() (cid.Cid, error) {
+	pref := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}
+	return pref.Sum([]byte("readiness probe"))
+}
+
+// handleReady reports whether the server's backing stores are actually
+// reachable, returning 503 with the failing component named when they
+// are not.
+func (s *Server) handleReady(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	probe, err := readinessProbeCid()
+	if err != nil {
+		return err
+	}
+
+	if s.Blockstore == nil {
+		return c.JSON(http.StatusServiceUnavailable,
+			map[string]string{"status": "unavailable", "reason": "blockstore not configured"})
+	}
+	if _, err := s.Blockstore.Has(ctx, probe); err != nil {
+		return c.JSON(http.StatusServiceUnavailable,
+			map[string]string{"status": "unavailable", "reason": fmt.Sprintf("blockstore: %s", err)})
+	}
+
+	if s.Ucans == nil {
+		return c.JSON(http.StatusServiceUnavailable,
+			map[string]string{"status": "unavailable", "reason": "ucan store not configured"})
+	}
+	s.Ucans.Get(probe)
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "ready"})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/labstack/echo/v4"
+)
+
+// brokenBs wraps a blockstore and fails every Has, simulating a backing
+// store that has gone away.
+type brokenBs struct {
+	blockstore.Blockstore
+}
+
+func (b brokenBs) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return false, fmt.Errorf("disk fell over")
+}
+
+func probeEndpoint(t *testing.T, s *Server, h echo.HandlerFunc) (int, map[string]string) {
+	t.Helper()
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := h(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, body
+}
+
+func TestHealthAndReady(t *testing.T) {
+	s := testServer(t)
+
+	code, body := probeEndpoint(t, s, s.handleHealth)
+	if code != http.StatusOK || body["status"] != "ok" {
+		t.Fatalf("health: got %d %v", code, body)
+	}
+
+	code, body = probeEndpoint(t, s, s.handleReady)
+	if code != http.StatusOK || body["status"] != "ready" {
+		t.Fatalf("ready: got %d %v", code, body)
+	}
+}
+
+func TestReadyFailsWithBrokenBlockstore(t *testing.T) {
+	s := testServer(t)
+	s.Blockstore = brokenBs{s.Blockstore}
+
+	code, body := probeEndpoint(t, s, s.handleReady)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d %v", code, body)
+	}
+	if body["reason"] == "" {
+		t.Fatal("not-ready response should name the failing component")
+	}
+
+	// liveness is unaffected, the process is still serving
+	if code, _ := probeEndpoint(t, s, s.handleHealth); code != http.StatusOK {
+		t.Fatalf("health should stay 200, got %d", code)
+	}
+}
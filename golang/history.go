@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+// recordHistory appends a freshly committed root to the user's history
+// and prunes entries beyond the configured depth. Pruned roots land on
+// the gc-eligible list, their blocks are no longer promised to
+// time-travel reads. A depth of zero keeps everything.
+func (s *Server) recordHistory(user string, root cid.Cid) {
+	s.hlk.Lock()
+	defer s.hlk.Unlock()
+
+	if s.history == nil {
+		s.history = make(map[string][]cid.Cid)
+	}
+	s.history[user] = append(s.history[user], root)
+
+	if s.HistoryDepth <= 0 {
+		return
+	}
+	for len(s.history[user]) > s.HistoryDepth {
+		s.gcEligible = append(s.gcEligible, s.history[user][0])
+		s.history[user] = s.history[user][1:]
+	}
+}
+
+// userHistory returns the retained historical roots for a user, oldest
+// first.
+func (s *Server) userHistory(user string) []cid.Cid {
+	s.hlk.Lock()
+	defer s.hlk.Unlock()
+	return append([]cid.Cid{}, s.history[user]...)
+}
+
+// dropHistory forgets a deleted user's retained roots.
+func (s *Server) dropHistory(user string) {
+	s.hlk.Lock()
+	defer s.hlk.Unlock()
+	delete(s.history, user)
+}
+
+// handleUserHistory lists the roots still available for time travel.
+func (s *Server) handleUserHistory(c echo.Context) error {
+	id := s.resolveUser(c.Param("id"))
+	if _, ok := s.lookupRoot(id); !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "no such user: "+id)
+	}
+
+	roots := []string{}
+	for _, r := range s.userHistory(id) {
+		roots = append(roots, s.renderCid(r))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user":  id,
+		"roots": roots,
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestHistoryDepthPruning(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.HistoryDepth = 2
+
+	// three commits with distinct roots
+	var roots []string
+	for i := 0; i < 3; i++ {
+		root, carb := testUserCar(t, "alice", int64(i))
+		if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root.String())
+	}
+
+	hist := s.userHistory("alice")
+	if len(hist) != 2 {
+		t.Fatalf("expected two retained roots, got %d", len(hist))
+	}
+	if hist[0].String() != roots[1] || hist[1].String() != roots[2] {
+		t.Fatalf("expected the last two roots retained, got %v", hist)
+	}
+
+	// the pruned root is now fair game for gc
+	if len(s.gcEligible) != 1 || s.gcEligible[0].String() != roots[0] {
+		t.Fatalf("expected the oldest root gc-eligible, got %v", s.gcEligible)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+// PreCommitHook is a synchronous policy check (eg external moderation)
+// run after an update has validated but before anything is committed.
+// Returning an error vetoes the update.
+type PreCommitHook interface {
+	Check(ctx context.Context, u *User, root cid.Cid) error
+}
+
+const defaultPreCommitTimeout = 5 * time.Second
+
+func (s *Server) preCommitTimeout() time.Duration {
+	if s.PreCommitTimeout > 0 {
+		return s.PreCommitTimeout
+	}
+	return defaultPreCommitTimeout
+}
+
+func (s *Server) preCommitStatus() int {
+	if s.PreCommitStatus > 0 {
+		return s.PreCommitStatus
+	}
+	return http.StatusForbidden
+}
+
+// runPreCommitHook applies the configured hook with a bounded deadline,
+// a stuck policy service fails the update rather than hanging it.
+func (s *Server) runPreCommitHook(ctx context.Context, u *User, root cid.Cid) error {
+	if s.PreCommit == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.preCommitTimeout())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.PreCommit.Check(ctx, u, root)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return echo.NewHTTPError(s.preCommitStatus(),
+				fmt.Sprintf("update vetoed: %s", err))
+		}
+		return nil
+	case <-ctx.Done():
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "pre-commit hook timed out")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+// didBlockHook vetoes commits for one did.
+type didBlockHook struct {
+	blocked string
+}
+
+func (h *didBlockHook) Check(ctx context.Context, u *User, root cid.Cid) error {
+	if u.DID == h.blocked || u.Name == h.blocked {
+		return fmt.Errorf("account is blocked by policy")
+	}
+	return nil
+}
+
+func TestPreCommitHookVeto(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.PreCommit = &didBlockHook{blocked: "alice"}
+
+	_, carb := testUserCar(t, "alice", 0)
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusForbidden {
+		t.Fatalf("expected a 403 veto, got %v", err)
+	}
+	if s.UserRoots["alice"].Defined() {
+		t.Fatal("a vetoed update must not commit")
+	}
+
+	// other users are unaffected
+	_, carb = testUserCar(t, "bob", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.UserRoots["bob"].Defined() {
+		t.Fatal("an approved update should commit")
+	}
+}
+
+// stallHook never answers.
+type stallHook struct{}
+
+func (stallHook) Check(ctx context.Context, u *User, root cid.Cid) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPreCommitHookTimeout(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.PreCommit = stallHook{}
+	s.PreCommitTimeout = 10 * time.Millisecond
+
+	_, carb := testUserCar(t, "alice", 0)
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 on hook timeout, got %v", err)
+	}
+}
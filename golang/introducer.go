@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// introductionBody is a (handle, did) binding vouched for by a trusted
+// identity service, used for migrations where users cant present their
+// own ucan.
+type introductionBody struct {
+	Handle     string `json:"handle"`
+	Did        string `json:"did"`
+	Introducer string `json:"introducer"`
+
+	// Sig is the introducer's signature over the introduction payload,
+	// base64 encoded.
+	Sig string `json:"sig"`
+}
+
+// introductionPayload is the exact byte string the introducer signs.
+func introductionPayload(handle, did string) []byte {
+	return []byte("atp-introduction\n" + handle + "\n" + did)
+}
+
+// handleRegisterIntroduction registers a user on the say-so of the
+// configured trusted introducer instead of a user-presented ucan.
+func (s *Server) handleRegisterIntroduction(c echo.Context) error {
+	if s.ReadOnly {
+		return echo.NewHTTPError(http.StatusForbidden, "this server is a read-only replica")
+	}
+	if s.TrustedIntroducer == "" || s.IntroducerKey == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "this server has no trusted introducer configured")
+	}
+
+	var body introductionBody
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid body")
+	}
+	if body.Handle == "" || body.Did == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "introduction missing handle or did")
+	}
+
+	if body.Introducer != s.TrustedIntroducer {
+		return echo.NewHTTPError(http.StatusUnauthorized,
+			"introduction is not from the trusted introducer")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(body.Sig)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "introduction signature is not valid base64")
+	}
+	if !ed25519.Verify(s.IntroducerKey, introductionPayload(body.Handle, body.Did), sig) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "introduction signature does not verify")
+	}
+
+	if err := s.putNewUser(body.Handle, body.Did); err != nil {
+		return err
+	}
+
+	return c.String(http.StatusOK, "registered")
+}
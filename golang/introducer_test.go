@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func postIntroduction(t *testing.T, s *Server, body introductionBody) error {
+	t.Helper()
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/register/introduction", bytes.NewReader(buf))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	return s.handleRegisterIntroduction(e.NewContext(req, rec))
+}
+
+func TestRegisterViaIntroduction(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := testServer(t)
+	s.TrustedIntroducer = "did:key:z6MkIntroducer"
+	s.IntroducerKey = pub
+
+	sig := ed25519.Sign(priv, introductionPayload("alice", "did:key:z6MkAlice"))
+	err = postIntroduction(t, s, introductionBody{
+		Handle:     "alice",
+		Did:        "did:key:z6MkAlice",
+		Introducer: s.TrustedIntroducer,
+		Sig:        base64.StdEncoding.EncodeToString(sig),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.UserDids["alice"] != "did:key:z6MkAlice" {
+		t.Fatal("introduction should have registered the binding")
+	}
+}
+
+func TestIntroductionFromUntrustedSigner(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := testServer(t)
+	s.TrustedIntroducer = "did:key:z6MkIntroducer"
+	s.IntroducerKey = pub
+
+	// right payload, wrong key
+	sig := ed25519.Sign(otherPriv, introductionPayload("bob", "did:key:z6MkBob"))
+	err = postIntroduction(t, s, introductionBody{
+		Handle:     "bob",
+		Did:        "did:key:z6MkBob",
+		Introducer: s.TrustedIntroducer,
+		Sig:        base64.StdEncoding.EncodeToString(sig),
+	})
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401, got %v", err)
+	}
+	if _, registered := s.UserRoots["bob"]; registered {
+		t.Fatal("a bad introduction must not register anyone")
+	}
+
+	// right key, wrong introducer did
+	err = postIntroduction(t, s, introductionBody{
+		Handle:     "bob",
+		Did:        "did:key:z6MkBob",
+		Introducer: "did:key:z6MkSomeoneElse",
+		Sig:        base64.StdEncoding.EncodeToString(sig),
+	})
+	he, ok = err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxExactFloat is the largest integer a float64 represents exactly,
+// anything integral beyond it may have lost precision on the way in.
+const maxExactFloat = 1 << 53
+
+// normalizeNumbers rewrites a decoded cbor value tree so integers stay
+// integers when surfaced as json. Decoders hand back float64 for some
+// integer values, which would render 7 as 7.0 (or worse, round large
+// values). In strict mode an integral float too large to be exact is an
+// error, lenient mode passes it through as-is.
+func normalizeNumbers(v interface{}, strict bool) (interface{}, error) {
+	switch t := v.(type) {
+	case float64:
+		if t != math.Trunc(t) {
+			return t, nil
+		}
+		if math.Abs(t) > maxExactFloat {
+			if strict {
+				return nil, fmt.Errorf("integer %v exceeds exact float range", t)
+			}
+			return t, nil
+		}
+		return int64(t), nil
+
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			nv, err := normalizeNumbers(val, strict)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = nv
+		}
+		return out, nil
+
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			nv, err := normalizeNumbers(val, strict)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = nv
+		}
+		return out, nil
+
+	default:
+		// ints, uint64s, strings, links and friends pass through exact
+		return v, nil
+	}
+}
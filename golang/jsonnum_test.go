@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestNormalizeNumbers(t *testing.T) {
+	// an integral float becomes a real integer
+	v, err := normalizeNumbers(float64(7), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := json.Marshal(v); string(out) != "7" {
+		t.Fatalf("expected 7, got %s", out)
+	}
+
+	// fractional values stay floats
+	v, err = normalizeNumbers(3.5, false)
+	if err != nil || v != 3.5 {
+		t.Fatalf("expected 3.5 unchanged, got %v %v", v, err)
+	}
+
+	// exact integer types pass through untouched
+	v, err = normalizeNumbers(map[string]interface{}{"big": uint64(1) << 60}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := json.Marshal(v); string(out) != `{"big":1152921504606846976}` {
+		t.Fatalf("large integer mangled: %s", out)
+	}
+
+	// an integral float past the exact range is suspect in strict mode
+	huge := float64(1 << 60)
+	if _, err := normalizeNumbers(huge, true); err == nil {
+		t.Fatal("strict mode should reject a possibly-rounded integer")
+	}
+	if _, err := normalizeNumbers(huge, false); err != nil {
+		t.Fatal("lenient mode should pass it through")
+	}
+}
+
+func TestGatewayPreservesLargeIntegers(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	nd, err := cbornode.WrapObject(map[string]interface{}{
+		"views": uint64(1) << 60,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+	c.SetParamNames("cid", "*")
+	c.SetParamValues(nd.Cid().String(), "views")
+
+	if err := s.handleIpfsGet(c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rec.Body.String(), "1152921504606846976") {
+		t.Fatalf("large integer not preserved: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type keyCacheEntry struct {
+	did     string
+	version string
+}
+
+// KeyCache caches resolved signing keys so we dont re-resolve and
+// re-parse the same user's key on every update. Entries are keyed by
+// (did, document version) so a rotated did:web document naturally
+// misses the cache, while did:key (where the key is the did) is cached
+// forever.
+type KeyCache struct {
+	lk   sync.Mutex
+	keys map[keyCacheEntry]interface{}
+
+	// ResolveKey fetches and parses the current key for a did, returning
+	// the key and the version (etag) of the document it came from.
+	ResolveKey func(ctx context.Context, did string) (interface{}, string, error)
+}
+
+func NewKeyCache(resolve func(ctx context.Context, did string) (interface{}, string, error)) *KeyCache {
+	return &KeyCache{
+		keys:       make(map[keyCacheEntry]interface{}),
+		ResolveKey: resolve,
+	}
+}
+
+// Get returns the signing key for a did, consulting the cache first.
+// docVersion is the version the caller believes is current ("" for
+// methods like did:key where the document cant change).
+func (k *KeyCache) Get(ctx context.Context, did, docVersion string) (interface{}, error) {
+	if strings.HasPrefix(did, "did:key:") {
+		// immutable, the version is irrelevant
+		docVersion = ""
+	}
+
+	ent := keyCacheEntry{did: did, version: docVersion}
+
+	k.lk.Lock()
+	key, ok := k.keys[ent]
+	k.lk.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	key, version, err := k.ResolveKey(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(did, "did:key:") {
+		version = ""
+	}
+
+	k.lk.Lock()
+	k.keys[keyCacheEntry{did: did, version: version}] = key
+	k.lk.Unlock()
+	return key, nil
+}
+
+// resolveSigningKey is the default resolver: did:key dids embed their
+// key so parsing is all it takes. Other methods (did:web) need a
+// document fetch, callers wanting that supply their own resolver via
+// Config.Keys.
+func resolveSigningKey(ctx context.Context, did string) (interface{}, string, error) {
+	pub, err := parseDidKey(did)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, "", nil
+}
+
+// signingKey fetches the verification key for a did through the cache,
+// so repeated updates from the same user dont re-parse (or, for
+// resolver-backed methods, re-fetch) the key every time.
+func (s *Server) signingKey(ctx context.Context, did string) (ed25519.PublicKey, error) {
+	var (
+		key interface{}
+		err error
+	)
+	if s.Keys != nil {
+		key, err = s.Keys.Get(ctx, did, "")
+	} else {
+		key, _, err = resolveSigningKey(ctx, did)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("resolved key for %s is not an ed25519 key", did)
+	}
+	return pub, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKeyCacheDidKeyImmutable(t *testing.T) {
+	ctx := context.TODO()
+
+	var resolutions int
+	kc := NewKeyCache(func(ctx context.Context, did string) (interface{}, string, error) {
+		resolutions++
+		return "parsed-" + did, "v1", nil
+	})
+
+	did := "did:key:z6MkExample"
+	for i := 0; i < 3; i++ {
+		if _, err := kc.Get(ctx, did, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if resolutions != 1 {
+		t.Fatalf("did:key should resolve once, resolved %d times", resolutions)
+	}
+}
+
+func TestKeyCacheRotationBustsCache(t *testing.T) {
+	ctx := context.TODO()
+
+	version := "v1"
+	var resolutions int
+	kc := NewKeyCache(func(ctx context.Context, did string) (interface{}, string, error) {
+		resolutions++
+		return "key-" + version, version, nil
+	})
+
+	did := "did:web:example.com"
+	if _, err := kc.Get(ctx, did, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kc.Get(ctx, did, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if resolutions != 1 {
+		t.Fatalf("same document version should hit the cache, resolved %d times", resolutions)
+	}
+
+	// the document rotates upstream
+	version = "v2"
+	key, err := kc.Get(ctx, did, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolutions != 2 {
+		t.Fatalf("a new document version should re-resolve, resolved %d times", resolutions)
+	}
+	if key != "key-v2" {
+		t.Fatalf("expected the rotated key, got %v", key)
+	}
+}
+
+func TestSigningKeyGoesThroughCache(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	var resolutions int
+	s.Keys = NewKeyCache(func(ctx context.Context, did string) (interface{}, string, error) {
+		resolutions++
+		return resolveSigningKey(ctx, did)
+	})
+
+	_, did := testSigningKey(t)
+	for i := 0; i < 3; i++ {
+		if _, err := s.signingKey(ctx, did); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if resolutions != 1 {
+		t.Fatalf("verification should resolve the key once, resolved %d times", resolutions)
+	}
+}
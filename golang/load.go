@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LoadHeader advertises current concurrent-update usage against the
+// ceiling, so well-behaved clients can back off before the server has
+// to refuse them.
+const LoadHeader = "X-Server-Load"
+
+const defaultMaxConcurrentUpdates = 8
+
+func (s *Server) maxConcurrentUpdates() int {
+	if s.MaxConcurrentUpdates > 0 {
+		return s.MaxConcurrentUpdates
+	}
+	return defaultMaxConcurrentUpdates
+}
+
+// beginUpdate claims an update slot, returning the release func. When
+// every slot is taken the caller gets a 429 instead.
+func (s *Server) beginUpdate() (func(), error) {
+	max := int64(s.maxConcurrentUpdates())
+	if atomic.AddInt64(&s.activeUpdates, 1) > max {
+		atomic.AddInt64(&s.activeUpdates, -1)
+		return nil, echo.NewHTTPError(http.StatusTooManyRequests, "too many concurrent updates")
+	}
+	return func() { atomic.AddInt64(&s.activeUpdates, -1) }, nil
+}
+
+// loadHeader renders usage as "active/ceiling".
+func (s *Server) loadHeader() string {
+	return fmt.Sprintf("%d/%d", atomic.LoadInt64(&s.activeUpdates), s.maxConcurrentUpdates())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUpdateLoadAccounting(t *testing.T) {
+	s := testServer(t)
+	s.MaxConcurrentUpdates = 4
+
+	// two updates already in flight
+	rel1, err := s.beginUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel2, err := s.beginUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rel3, err := s.beginUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.loadHeader(); got != "3/4" {
+		t.Fatalf("expected elevated load to show, got %q", got)
+	}
+	rel3()
+
+	if got := s.loadHeader(); got != "2/4" {
+		t.Fatalf("expected load to drop after release, got %q", got)
+	}
+
+	// saturate and confirm the refusal
+	rel3, err = s.beginUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel4, err := s.beginUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.beginUpdate()
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 at the ceiling, got %v", err)
+	}
+
+	rel1()
+	rel2()
+	rel3()
+	rel4()
+	if got := s.loadHeader(); got != "0/4" {
+		t.Fatalf("expected idle load, got %q", got)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "sync"
+
+// userLocks hands out one mutex per user, so commits for a single user
+// apply strictly in order without different users contending on a
+// global lock.
+type userLocks struct {
+	lk sync.Mutex
+	m  map[string]*sync.Mutex
+}
+
+// lock takes the per-user mutex, creating it on first use, and returns
+// the matching unlock.
+func (u *userLocks) lock(name string) func() {
+	u.lk.Lock()
+	if u.m == nil {
+		u.m = make(map[string]*sync.Mutex)
+	}
+	l, ok := u.m[name]
+	if !ok {
+		l = &sync.Mutex{}
+		u.m[name] = l
+	}
+	u.lk.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestCommitsSerializePerUserOnly(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// hold alice's commit lock as if one of her updates were mid-commit
+	unlock := s.commitLocks.lock("alice")
+
+	aliceDone := make(chan error, 1)
+	go func() {
+		_, carb := buildMustUserCar("alice", 0)
+		aliceDone <- s.runUpdate(ctx, bytes.NewReader(carb))
+	}()
+
+	// bob is not blocked by alice's in-flight commit
+	_, bobCar := buildMustUserCar("bob", 0)
+	bobDone := make(chan error, 1)
+	go func() {
+		bobDone <- s.runUpdate(ctx, bytes.NewReader(bobCar))
+	}()
+	select {
+	case err := <-bobDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob's update blocked behind alice's commit lock")
+	}
+
+	// alice's second update is still waiting on the lock
+	select {
+	case err := <-aliceDone:
+		t.Fatalf("alice's update finished while her lock was held: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case err := <-aliceDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("alice's update never completed after unlock")
+	}
+}
+
+func TestUserLocksIndependent(t *testing.T) {
+	var ul userLocks
+
+	// holding one user's lock doesnt block another's
+	unlock := ul.lock("alice")
+	done := make(chan struct{})
+	go func() {
+		ul.lock("bob")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob's lock blocked behind alice's")
+	}
+	unlock()
+
+	// the same user always gets the same mutex back
+	for i := 0; i < 3; i++ {
+		ul.lock("alice")()
+	}
+	if len(ul.m) != 2 {
+		t.Fatalf("expected 2 distinct user locks, have %d", len(ul.m))
+	}
+}
+
+// buildMustUserCar is buildTestUserCar for goroutines, where t.Fatal
+// isnt allowed.
+func buildMustUserCar(name string, nextPost int64) (root cid.Cid, carb []byte) {
+	r, b, err := buildTestUserCar(name, nextPost)
+	if err != nil {
+		panic(err)
+	}
+	return r, b
+}
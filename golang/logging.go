@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// requestIDCtxKey keys the correlation id in a request context, so work
+// deep inside the update path can tag its logs without threading echo
+// through every call.
+type requestIDCtxKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey{}, id)
+}
+
+// requestID pulls the correlation id out of ctx, empty when the work
+// didnt start from an http request.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware assigns every request an id (honoring an inbound
+// X-Request-Id from a proxy) and copies it into the request context
+// where logOp picks it up.
+func (s *Server) requestIDMiddleware() echo.MiddlewareFunc {
+	return middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		RequestIDHandler: func(c echo.Context, id string) {
+			req := c.Request()
+			c.SetRequest(req.WithContext(withRequestID(req.Context(), id)))
+		},
+	})
+}
+
+// logger returns the configured structured logger, falling back to the
+// process default so call sites never nil-check.
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// logOp emits one structured line for a server operation, tagged with
+// the request id from ctx when there is one.
+func (s *Server) logOp(ctx context.Context, msg string, args ...interface{}) {
+	l := s.logger()
+	if id := requestID(ctx); id != "" {
+		l = l.With("request_id", id)
+	}
+	l.Info(msg, args...)
+}
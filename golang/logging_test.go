@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUpdateLogsCarryRequestID(t *testing.T) {
+	s := testServer(t)
+
+	var buf bytes.Buffer
+	s.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	// the context carries the id exactly as the middleware would set it
+	ctx := withRequestID(context.TODO(), "req-123")
+	_, carb := testUserCar(t, "alice", 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "user update committed") {
+		t.Fatalf("commit did not log: %q", out)
+	}
+	if !strings.Contains(out, "request_id=req-123") {
+		t.Fatalf("log line is missing the request id: %q", out)
+	}
+	if !strings.Contains(out, "user=alice") {
+		t.Fatalf("log line is missing the user: %q", out)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	s := testServer(t)
+
+	e := echo.New()
+	e.Use(s.requestIDMiddleware())
+	e.GET("/probe", func(c echo.Context) error {
+		return c.String(http.StatusOK, requestID(c.Request().Context()))
+	})
+
+	// a generated id lands in the context and the response header alike
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/probe", nil))
+	if rec.Body.String() == "" {
+		t.Fatal("no request id reached the handler context")
+	}
+	if rec.Body.String() != rec.Header().Get(echo.HeaderXRequestID) {
+		t.Fatalf("context id %q does not match header %q",
+			rec.Body.String(), rec.Header().Get(echo.HeaderXRequestID))
+	}
+
+	// an id assigned upstream is kept, not replaced
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/probe", nil)
+	req.Header.Set(echo.HeaderXRequestID, "proxy-42")
+	e.ServeHTTP(rec, req)
+	if rec.Body.String() != "proxy-42" {
+		t.Fatalf("inbound request id not propagated, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	badger "github.com/ipfs/go-ds-badger"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/labstack/echo/v4"
+)
+
+// defaultListenAddr is the port this server has always squatted on.
+const defaultListenAddr = ":2583"
+
+// resolveListenAddr picks the listen address: an explicit -addr flag
+// wins, then the ATPROTO_ADDR environment, then the historical default.
+func resolveListenAddr(flagAddr, envAddr string) string {
+	if flagAddr != "" {
+		return flagAddr
+	}
+	if envAddr != "" {
+		return envAddr
+	}
+	return defaultListenAddr
+}
+
+// openDatastore picks the backing store: a persistent badger store
+// when a datadir is given, the usual in-memory map otherwise.
+func openDatastore(datadir string) (datastore.Batching, error) {
+	if datadir == "" {
+		return dsync.MutexWrap(datastore.NewMapDatastore()), nil
+	}
+	return badger.NewDatastore(datadir, &badger.DefaultOptions)
+}
+
+func main() {
+	replicateFrom := flag.String("replicate-from", "", "run as a read-only replica of the given primary url")
+	datadir := flag.String("datadir", "", "directory for a persistent datastore, in-memory when unset")
+	addr := flag.String("addr", "", fmt.Sprintf("listen address (default %s, or ATPROTO_ADDR)", defaultListenAddr))
+	serverInit := flag.Bool("server-init", false, "let /register/alt bootstrap new repos server-side")
+	serverKey := flag.String("server-key", "", "file holding a hex-encoded ed25519 seed for the server's signing identity (or ATPROTO_SERVER_KEY)")
+	blobDir := flag.String("blob-dir", "", "directory for offloading raw and oversized blocks out of the blockstore")
+	blobThreshold := flag.Int("blob-threshold", 0, "size in bytes above which blocks go to the blob dir (default 1MiB)")
+	flag.Parse()
+
+	ds, err := openDatastore(*datadir)
+	if err != nil {
+		panic(err)
+	}
+
+	key, err := loadServerKey(*serverKey, os.Getenv("ATPROTO_SERVER_KEY"))
+	if err != nil {
+		panic(err)
+	}
+
+	var blobs BlobStore
+	if *blobDir != "" {
+		blobs, err = NewDirBlobStore(*blobDir)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	s, err := NewServer(Config{
+		Blockstore:    blockstore.NewBlockstore(ds),
+		Roots:         NewDsRootStore(ds),
+		Key:           key,
+		Blobs:         blobs,
+		BlobThreshold: *blobThreshold,
+		ServerInit:    *serverInit,
+		Debug:         os.Getenv("ATP_DEBUG") != "",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	cas, err := loadAdminClientCAs()
+	if err != nil {
+		panic(err)
+	}
+	s.AdminClientCAs = cas
+
+	if err := s.loadPersistedUsers(); err != nil {
+		panic(err)
+	}
+
+	if cleaned := s.reconcileUserMaps(); len(cleaned) > 0 {
+		fmt.Println("cleaned up inconsistent user entries:", cleaned)
+	}
+
+	// when tls terminates elsewhere, the plaintext port can redirect or
+	// refuse instead of serving
+	if mode := os.Getenv("ATP_PLAINTEXT_MODE"); mode == "redirect" || mode == "refuse" {
+		addr := os.Getenv("ATP_PLAINTEXT_ADDR")
+		if addr == "" {
+			addr = ":2582"
+		}
+		go func() {
+			panic(http.ListenAndServe(addr, plaintextHandler(mode, os.Getenv("ATP_TLS_HOST"))))
+		}()
+	}
+
+	go s.runUpdateWorker(context.Background())
+	go s.RootLog.runPruner(context.Background(), time.Minute)
+
+	if *replicateFrom != "" {
+		s.ReadOnly = true
+		go NewReplicator(s, *replicateFrom).Run(context.Background())
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler
+	e.Use(s.requestIDMiddleware())
+	e.Use(s.metricsMiddleware)
+	e.GET("/", s.handleRoot)
+	e.GET("/.well-known/did.json", s.handleGetDid)
+	e.GET("/health", s.handleHealth)
+	e.GET("/ready", s.handleReady)
+	e.GET("/metrics", s.handleMetrics)
+	e.POST("/register", s.handleRegister)
+	e.POST("/register/alt", s.handleRegisterUserAlt, s.deprecated)
+	e.POST("/register/introduction", s.handleRegisterIntroduction)
+	e.POST("/update", s.handleUserUpdate)
+	e.POST("/auth/capabilities", s.handleAuthCapabilities)
+	e.GET("/update/status/:id", s.handleUpdateStatus)
+	e.GET("/log/:seq", s.handleGetRootLogEntry)
+	e.GET("/subscribe", s.handleSubscribe)
+	e.GET("/users", s.handleListUsers)
+	e.GET("/user/:id", s.handleGetUser)
+	e.DELETE("/user/:id", s.handleDeleteUser)
+	e.GET("/user/:id/entries", s.handleListEntries)
+	e.GET("/user/:id/posts", s.handleListPosts)
+	e.GET("/user/:id/history", s.handleUserHistory)
+	e.GET("/user/:id/signed-root", s.handleGetSignedRoot)
+	e.GET("/ipfs/:cid/*", s.handleIpfsGet)
+
+	admin := e.Group("/admin", s.requireAdminClientCert)
+	admin.GET("/info", s.handleAdminInfo)
+	admin.POST("/canonicalize", s.handleCanonicalize)
+	admin.DELETE("/user/:id", s.handleAdminDeleteUser)
+	admin.POST("/block/:cid/quarantine", s.handleQuarantineBlock)
+	admin.POST("/block/:cid/unquarantine", s.handleUnquarantineBlock)
+
+	e.POST("/cache/did/invalidate", s.handleDidInvalidate, s.requireAdminClientCert)
+
+	listen := resolveListenAddr(*addr, os.Getenv("ATPROTO_ADDR"))
+	fmt.Println("listening on", listen)
+	go func() {
+		if err := e.Start(listen); err != nil && err != http.ErrServerClosed {
+			panic(err)
+		}
+	}()
+
+	// a signal drains in-flight requests before the datastore closes, so
+	// an update that made it past validation gets to finish its copy
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	fmt.Println("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		fmt.Println("shutdown:", err)
+	}
+	if err := ds.Close(); err != nil {
+		fmt.Println("closing datastore:", err)
+	}
+}
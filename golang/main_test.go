@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestResolveListenAddr(t *testing.T) {
+	// the flag wins over everything
+	if got := resolveListenAddr(":9999", ":8888"); got != ":9999" {
+		t.Fatalf("flag should win, got %q", got)
+	}
+
+	// without a flag the environment decides
+	if got := resolveListenAddr("", ":8888"); got != ":8888" {
+		t.Fatalf("env should win over the default, got %q", got)
+	}
+
+	// with neither, the historical default stands
+	if got := resolveListenAddr("", ""); got != defaultListenAddr {
+		t.Fatalf("expected the default %q, got %q", defaultListenAddr, got)
+	}
+}
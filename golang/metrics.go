@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// histogram is a fixed-bucket counter, enough to answer "what do real
+// uploads look like" without pulling in a metrics library yet.
+type histogram struct {
+	lk     sync.Mutex
+	bounds []int64
+	counts []int64
+	sum    int64
+}
+
+// newHistogram buckets observations by upper bound, with an implicit
+// overflow bucket at the end.
+func newHistogram(bounds ...int64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) Observe(v int64) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	h.sum += v
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+			return
+		}
+	}
+	h.counts[len(h.counts)-1]++
+}
+
+// Counts returns a snapshot of the bucket counters.
+func (h *histogram) Counts() []int64 {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	out := make([]int64, len(h.counts))
+	copy(out, h.counts)
+	return out
+}
+
+// Sum returns the running total of everything observed.
+func (h *histogram) Sum() int64 {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	return h.sum
+}
+
+func (h *histogram) Total() int64 {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	var n int64
+	for _, c := range h.counts {
+		n += c
+	}
+	return n
+}
+
+// WalkMetrics records the observed shape of update dags, which is what
+// operators need to set the depth and walk-size caps sensibly.
+type WalkMetrics struct {
+	Depth  *histogram
+	Width  *histogram
+	Blocks *histogram
+}
+
+func NewWalkMetrics() *WalkMetrics {
+	return &WalkMetrics{
+		Depth:  newHistogram(1, 2, 4, 8, 16, 32, 64),
+		Width:  newHistogram(1, 2, 4, 8, 16, 32, 64, 128, 256),
+		Blocks: newHistogram(1, 8, 64, 512, 4096, 32768),
+	}
+}
+
+// observeWalk records one update's dag shape.
+func (m *WalkMetrics) observeWalk(st *walkStats) {
+	if m == nil {
+		return
+	}
+	m.Depth.Observe(int64(st.maxDepth))
+	m.Width.Observe(int64(st.maxWidth))
+	m.Blocks.Observe(int64(st.blocks))
+}
+
+// counter is a monotonically increasing value, safe for concurrent use.
+type counter struct{ n int64 }
+
+func (c *counter) Inc()         { atomic.AddInt64(&c.n, 1) }
+func (c *counter) Value() int64 { return atomic.LoadInt64(&c.n) }
+
+// Metrics is the registry behind /metrics. It renders the prometheus
+// text exposition format directly, the histogram above already does the
+// bucketing, so we still dont need the client library.
+type Metrics struct {
+	Updates      counter
+	UpdateBytes  *histogram
+	UcanFailures counter
+
+	lk        sync.Mutex
+	requests  map[string]*counter
+	latencies map[string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		UpdateBytes: newHistogram(1<<10, 1<<14, 1<<18, 1<<22, 1<<26),
+		requests:    make(map[string]*counter),
+		latencies:   make(map[string]*histogram),
+	}
+}
+
+// observeRequest records one served request for a route, with its
+// latency in milliseconds.
+func (m *Metrics) observeRequest(path string, ms int64) {
+	if m == nil {
+		return
+	}
+	m.lk.Lock()
+	c, ok := m.requests[path]
+	if !ok {
+		c = &counter{}
+		m.requests[path] = c
+	}
+	h, ok := m.latencies[path]
+	if !ok {
+		h = newHistogram(1, 5, 10, 50, 100, 500, 1000, 5000)
+		m.latencies[path] = h
+	}
+	m.lk.Unlock()
+
+	c.Inc()
+	h.Observe(ms)
+}
+
+// observeUpdate records one committed update and how many bytes its
+// copy moved into the main store.
+func (m *Metrics) observeUpdate(copied int64) {
+	if m == nil {
+		return
+	}
+	m.Updates.Inc()
+	m.UpdateBytes.Observe(copied)
+}
+
+func (m *Metrics) observeUcanFailure() {
+	if m == nil {
+		return
+	}
+	m.UcanFailures.Inc()
+}
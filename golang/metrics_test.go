@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/qri-io/ucan"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogram(1, 4, 16)
+
+	h.Observe(1)
+	h.Observe(3)
+	h.Observe(100)
+
+	counts := h.Counts()
+	if counts[0] != 1 || counts[1] != 1 || counts[3] != 1 {
+		t.Fatalf("unexpected bucket counts: %v", counts)
+	}
+	if h.Total() != 3 {
+		t.Fatalf("expected 3 observations, got %d", h.Total())
+	}
+}
+
+func TestWalkShapeMetrics(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.Walks = NewWalkMetrics()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+
+	// a three-deep chain
+	leaf, err := cbornode.WrapObject(map[string]string{"v": "leaf"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mid, err := cbornode.WrapObject(map[string]interface{}{"next": leaf.Cid()}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := cbornode.WrapObject(map[string]interface{}{"next": mid.Cid()}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a one-deep fan-out of three links
+	wide, err := cbornode.WrapObject(map[string]interface{}{
+		"a": leaf.Cid(), "b": mid.Cid(), "c": top.Cid(),
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, nd := range []blocks.Block{leaf, mid, top, wide} {
+		if err := bs.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.ensureGraphWalkability(ctx, &User{PostsRoot: top.Cid()}, bs); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ensureGraphWalkability(ctx, &User{PostsRoot: wide.Cid()}, bs); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Walks.Depth.Total() != 2 {
+		t.Fatalf("expected two walks observed, got %d", s.Walks.Depth.Total())
+	}
+
+	// the chain walk reaches depth 3, landing in the (2, 4] bucket
+	if s.Walks.Depth.Counts()[2] != 2 {
+		t.Fatalf("unexpected depth buckets: %v", s.Walks.Depth.Counts())
+	}
+
+	// the fan-out has width 3, the chain width 1
+	width := s.Walks.Width.Counts()
+	if width[0] != 1 || width[2] != 1 {
+		t.Fatalf("unexpected width buckets: %v", width)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.Metrics = NewMetrics()
+	s.Walks = NewWalkMetrics()
+
+	// a committed update bumps the counter and the copied-bytes histogram
+	_, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Metrics.Updates.Value(); got != 1 {
+		t.Fatalf("expected 1 update observed, got %d", got)
+	}
+	if s.Metrics.UpdateBytes.Sum() == 0 {
+		t.Fatal("copied bytes should have been observed")
+	}
+
+	// a tokenless request is a ucan verification failure
+	att := ucan.Attenuation{Cap: twitterCaps.Cap("POST"), Rsc: newAccountRsc("twitter", "alice")}
+	if _, err := s.checkTokenCap(httptest.NewRequest("POST", "/update", nil), att); err == nil {
+		t.Fatal("expected the tokenless check to fail")
+	}
+	if got := s.Metrics.UcanFailures.Value(); got != 1 {
+		t.Fatalf("expected 1 ucan failure, got %d", got)
+	}
+
+	// one timed request for the per-endpoint series
+	s.Metrics.observeRequest("/update", 7)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := s.handleMetrics(e.NewContext(httptest.NewRequest("GET", "/metrics", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"atp_updates_total 1",
+		"atp_ucan_failures_total 1",
+		"atp_update_copied_bytes_count 1",
+		"atp_walk_blocks_count 1",
+		`atp_http_requests_total{path="/update"} 1`,
+		`atp_http_request_duration_ms_bucket{path="/update",le="10"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output missing %q:\n%s", want, body)
+		}
+	}
+}
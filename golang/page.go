@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageDefault returns the configured default page size.
+func (s *Server) pageDefault() int {
+	if s.DefaultPageSize > 0 {
+		return s.DefaultPageSize
+	}
+	return defaultPageSize
+}
+
+// pageMax returns the configured hard cap on page sizes.
+func (s *Server) pageMax() int {
+	if s.MaxPageSize > 0 {
+		return s.MaxPageSize
+	}
+	return maxPageSize
+}
+
+// parsePage reads the limit and cursor query params every listing
+// endpoint takes, so they all agree on defaults and the hard max. An
+// over-max limit is clamped rather than rejected, a malformed one is a
+// 400.
+func (s *Server) parsePage(c echo.Context) (int, string, error) {
+	limit := s.pageDefault()
+	if ls := c.QueryParam("limit"); ls != "" {
+		n, err := strconv.Atoi(ls)
+		if err != nil || n <= 0 {
+			return 0, "", echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = n
+	}
+	if limit > s.pageMax() {
+		limit = s.pageMax()
+	}
+
+	cursor := c.QueryParam("cursor")
+	if strings.ContainsAny(cursor, "\n\r") {
+		return 0, "", echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+	}
+
+	return limit, cursor, nil
+}
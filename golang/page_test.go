@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func pageCtx(query string) echo.Context {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/user/alice/entries"+query, nil)
+	return e.NewContext(req, httptest.NewRecorder())
+}
+
+func TestParsePage(t *testing.T) {
+	s := testServer(t)
+
+	limit, cursor, err := s.parsePage(pageCtx(""))
+	if err != nil || limit != defaultPageSize || cursor != "" {
+		t.Fatalf("unexpected defaults: %d %q %v", limit, cursor, err)
+	}
+
+	limit, cursor, err = s.parsePage(pageCtx("?limit=5&cursor=abc"))
+	if err != nil || limit != 5 || cursor != "abc" {
+		t.Fatalf("unexpected parse: %d %q %v", limit, cursor, err)
+	}
+
+	// an over-max limit is clamped, not rejected
+	limit, _, err = s.parsePage(pageCtx("?limit=999999"))
+	if err != nil || limit != maxPageSize {
+		t.Fatalf("expected clamping to %d, got %d %v", maxPageSize, limit, err)
+	}
+
+	s.MaxPageSize = 10
+	limit, _, err = s.parsePage(pageCtx("?limit=50"))
+	if err != nil || limit != 10 {
+		t.Fatalf("expected the configured max of 10, got %d %v", limit, err)
+	}
+
+	for _, q := range []string{"?limit=0", "?limit=-3", "?limit=abc"} {
+		if _, _, err := s.parsePage(pageCtx(q)); err == nil {
+			t.Fatalf("expected %q to be rejected", q)
+		}
+	}
+
+	if _, _, err := s.parsePage(pageCtx("?cursor=a%0Ab")); err == nil {
+		t.Fatal("expected a cursor with a newline to be rejected")
+	}
+}
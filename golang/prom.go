@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// promHistogram writes one histogram in the prometheus text exposition
+// format: cumulative buckets, then the sum and count series.
+func promHistogram(w *bytes.Buffer, name, labels string, h *histogram) {
+	sep := func(le string) string {
+		if labels == "" {
+			return fmt.Sprintf(`{le=%q}`, le)
+		}
+		return fmt.Sprintf(`{%s,le=%q}`, labels, le)
+	}
+
+	var cum int64
+	counts := h.Counts()
+	for i, n := range counts {
+		cum += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = fmt.Sprintf("%d", h.bounds[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, sep(le), cum)
+	}
+
+	suffix := ""
+	if labels != "" {
+		suffix = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %d\n", name, suffix, h.Sum())
+	fmt.Fprintf(w, "%s_count%s %d\n", name, suffix, cum)
+}
+
+// metricsMiddleware counts and times every request by route template.
+func (s *Server) metricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		s.Metrics.observeRequest(c.Path(), time.Since(start).Milliseconds())
+		return err
+	}
+}
+
+// handleMetrics renders everything the server counts in the prometheus
+// text format. Unauthenticated like the health probes; nothing here is
+// more sensitive than /admin/info already exposes.
+func (s *Server) handleMetrics(c echo.Context) error {
+	m := s.Metrics
+	if m == nil {
+		return c.String(http.StatusOK, "")
+	}
+
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintf(buf, "# TYPE atp_updates_total counter\natp_updates_total %d\n", m.Updates.Value())
+	fmt.Fprintf(buf, "# TYPE atp_ucan_failures_total counter\natp_ucan_failures_total %d\n", m.UcanFailures.Value())
+
+	fmt.Fprintf(buf, "# TYPE atp_update_copied_bytes histogram\n")
+	promHistogram(buf, "atp_update_copied_bytes", "", m.UpdateBytes)
+
+	if s.Walks != nil {
+		fmt.Fprintf(buf, "# TYPE atp_walk_blocks histogram\n")
+		promHistogram(buf, "atp_walk_blocks", "", s.Walks.Blocks)
+		fmt.Fprintf(buf, "# TYPE atp_walk_depth histogram\n")
+		promHistogram(buf, "atp_walk_depth", "", s.Walks.Depth)
+	}
+
+	m.lk.Lock()
+	paths := make([]string, 0, len(m.requests))
+	for p := range m.requests {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	requests := make(map[string]*counter, len(paths))
+	latencies := make(map[string]*histogram, len(paths))
+	for _, p := range paths {
+		requests[p] = m.requests[p]
+		latencies[p] = m.latencies[p]
+	}
+	m.lk.Unlock()
+
+	fmt.Fprintf(buf, "# TYPE atp_http_requests_total counter\n")
+	for _, p := range paths {
+		fmt.Fprintf(buf, "atp_http_requests_total{path=%q} %d\n", p, requests[p].Value())
+	}
+	fmt.Fprintf(buf, "# TYPE atp_http_request_duration_ms histogram\n")
+	for _, p := range paths {
+		promHistogram(buf, "atp_http_request_duration_ms", fmt.Sprintf("path=%q", p), latencies[p])
+	}
+
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4", buf.Bytes())
+}
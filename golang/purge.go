@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/labstack/echo/v4"
+	"github.com/qri-io/ucan"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// collectReachable records every block reachable from root into seen.
+// Blocks missing from the store are skipped rather than failing, a
+// half-imported dag shouldnt block deleting its owner.
+func collectReachable(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, seen map[cid.Cid]bool) error {
+	if seen[root] {
+		return nil
+	}
+
+	blk, err := bs.Get(ctx, root)
+	if err != nil {
+		return nil
+	}
+	seen[root] = true
+
+	if root.Prefix().Codec == cid.Raw {
+		return nil
+	}
+
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(l cid.Cid) {
+		links = append(links, l)
+	}); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if err := collectReachable(ctx, bs, l, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteUser removes a user from the registry and, when purge is set,
+// synchronously deletes every block reachable only from their root.
+// Shared blocks stay for the users still referencing them.
+func (s *Server) deleteUser(ctx context.Context, id string, purge bool) (purged, kept int, err error) {
+	s.ulk.Lock()
+	id = s.resolveUserLocked(id)
+	root, ok := s.UserRoots[id]
+	if !ok {
+		s.ulk.Unlock()
+		return 0, 0, echo.NewHTTPError(http.StatusNotFound, "no such user: "+id)
+	}
+	delete(s.UserRoots, id)
+	delete(s.UserDids, id)
+	s.ulk.Unlock()
+
+	s.dropHistory(id)
+	if s.Roots != nil {
+		if err := s.Roots.Delete(id); err != nil {
+			return 0, 0, err
+		}
+		if ds, ok := s.Roots.(DidStore); ok {
+			if err := ds.DeleteDid(id); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	if !purge || !root.Defined() {
+		return 0, 0, nil
+	}
+
+	mine := make(map[cid.Cid]bool)
+	if err := collectReachable(ctx, s.Blockstore, root, mine); err != nil {
+		return 0, 0, err
+	}
+
+	// everything the remaining users can still reach is off limits
+	s.ulk.Lock()
+	others := make([]cid.Cid, 0, len(s.UserRoots))
+	for _, other := range s.UserRoots {
+		if other.Defined() {
+			others = append(others, other)
+		}
+	}
+	s.ulk.Unlock()
+
+	shared := make(map[cid.Cid]bool)
+	for _, other := range others {
+		if err := collectReachable(ctx, s.Blockstore, other, shared); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for blk := range mine {
+		if shared[blk] {
+			kept++
+			continue
+		}
+		if err := s.Blockstore.DeleteBlock(ctx, blk); err != nil {
+			return purged, kept, err
+		}
+		purged++
+	}
+	return purged, kept, nil
+}
+
+// handleAdminDeleteUser removes a user from the registry, with
+// ?purge=true also reclaiming their exclusively-owned blocks.
+func (s *Server) handleAdminDeleteUser(c echo.Context) error {
+	id := c.Param("id")
+	purged, kept, err := s.deleteUser(c.Request().Context(), id, c.QueryParam("purge") == "true")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user":   id,
+		"purged": purged,
+		"shared": kept,
+	})
+}
+
+// handleDeleteUser lets a user delete their own account. The token must
+// grant the DELETE capability on exactly this account, and the user's
+// exclusively-owned blocks go with it.
+func (s *Server) handleDeleteUser(c echo.Context) error {
+	if s.ReadOnly {
+		return echo.NewHTTPError(http.StatusForbidden, "this server is a read-only replica")
+	}
+
+	id := c.Param("id")
+	att := ucan.Attenuation{
+		Cap: twitterCaps.Cap("DELETE"),
+		Rsc: newAccountRsc("twitter", id),
+	}
+	if _, err := s.checkTokenCap(c.Request(), att); err != nil {
+		return err
+	}
+
+	purged, kept, err := s.deleteUser(c.Request().Context(), id, true)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user":   id,
+		"purged": purged,
+		"shared": kept,
+	})
+}
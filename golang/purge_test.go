@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestAdminPurgeKeepsSharedBlocks(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// two users whose roots both link a common subtree
+	shared, err := cbornode.WrapObject(map[string]string{"v": "shared"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceOnly, err := cbornode.WrapObject(map[string]string{"v": "alice"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceRoot, err := cbornode.WrapObject(map[string]interface{}{
+		"shared": shared.Cid(), "own": aliceOnly.Cid(),
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobRoot, err := cbornode.WrapObject(map[string]interface{}{
+		"shared": shared.Cid(),
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*cbornode.Node{shared, aliceOnly, aliceRoot, bobRoot} {
+		if err := s.Blockstore.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.UserRoots["alice"] = aliceRoot.Cid()
+	s.UserDids["alice"] = "did:key:zAlice"
+	s.UserRoots["bob"] = bobRoot.Cid()
+	s.UserDids["bob"] = "did:key:zBob"
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("DELETE", "/?purge=true", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s.handleAdminDeleteUser(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.UserRoots["alice"]; ok {
+		t.Fatal("alice should be gone from the registry")
+	}
+
+	checks := []struct {
+		c    cid.Cid
+		want bool
+	}{
+		{aliceRoot.Cid(), false},
+		{aliceOnly.Cid(), false},
+		{shared.Cid(), true},
+		{bobRoot.Cid(), true},
+	}
+	for _, chk := range checks {
+		has, err := s.Blockstore.Has(ctx, chk.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has != chk.want {
+			t.Fatalf("block %s: has=%v, want %v", chk.c, has, chk.want)
+		}
+	}
+}
+
+func TestAdminDeleteUnknownUser(t *testing.T) {
+	s := testServer(t)
+
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest("DELETE", "/", nil), httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues("nobody")
+	err := s.handleAdminDeleteUser(c)
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 404 {
+		t.Fatalf("expected 404 for an unknown user, got %v", err)
+	}
+}
+
+func TestDeleteUserKeepsSharedSubtree(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	shared, err := cbornode.WrapObject(map[string]string{"v": "both"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceRoot, err := cbornode.WrapObject(map[string]interface{}{
+		"sub": shared.Cid(),
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobRoot, err := cbornode.WrapObject(map[string]interface{}{
+		"sub": shared.Cid(), "who": "bob",
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*cbornode.Node{shared, aliceRoot, bobRoot} {
+		if err := s.Blockstore.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.UserRoots["alice"] = aliceRoot.Cid()
+	s.UserDids["alice"] = "did:key:zAlice"
+	s.UserRoots["bob"] = bobRoot.Cid()
+	s.UserDids["bob"] = "did:key:zBob"
+
+	purged, kept, err := s.deleteUser(ctx, "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 || kept != 1 {
+		t.Fatalf("purged=%d kept=%d, want 1/1", purged, kept)
+	}
+
+	if _, ok := s.UserDids["alice"]; ok {
+		t.Fatal("alice's did mapping should be gone")
+	}
+	has, err := s.Blockstore.Has(ctx, shared.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Fatal("shared subtree must survive alice's deletion")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/labstack/echo/v4"
+)
+
+// quarantineSet tracks blocks an operator has pulled from circulation
+// for moderation or legal reasons. The bytes stay in the store (they
+// may be evidence), but nothing serves or walks them.
+type quarantineSet struct {
+	lk sync.Mutex
+	m  map[cid.Cid]bool
+}
+
+func (q *quarantineSet) add(c cid.Cid) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.m == nil {
+		q.m = make(map[cid.Cid]bool)
+	}
+	q.m[c] = true
+}
+
+func (q *quarantineSet) remove(c cid.Cid) bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	ok := q.m[c]
+	delete(q.m, c)
+	return ok
+}
+
+func (q *quarantineSet) has(c cid.Cid) bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.m[c]
+}
+
+// quarantineTombstone is what reads of a quarantined block get instead
+// of its bytes.
+func (s *Server) quarantineTombstone(c echo.Context, cc cid.Cid) error {
+	return c.JSON(http.StatusUnavailableForLegalReasons, map[string]interface{}{
+		"quarantined": true,
+		"cid":         s.renderCid(cc),
+	})
+}
+
+// handleQuarantineBlock records a block cid in the quarantine set.
+func (s *Server) handleQuarantineBlock(c echo.Context) error {
+	cc, err := parseCidParam(c.Param("cid"))
+	if err != nil {
+		return err
+	}
+
+	s.Quarantine.add(cc)
+	return c.JSON(http.StatusOK, map[string]interface{}{"cid": s.renderCid(cc), "quarantined": true})
+}
+
+// handleUnquarantineBlock lifts a quarantine.
+func (s *Server) handleUnquarantineBlock(c echo.Context) error {
+	cc, err := parseCidParam(c.Param("cid"))
+	if err != nil {
+		return err
+	}
+
+	was := s.Quarantine.remove(cc)
+	return c.JSON(http.StatusOK, map[string]interface{}{"cid": s.renderCid(cc), "quarantined": false, "was": was})
+}
+
+// quarantinedError aborts a read that reached a quarantined block, so
+// the handler can answer with the tombstone instead of the bytes.
+type quarantinedError struct {
+	cid cid.Cid
+}
+
+func (e *quarantinedError) Error() string {
+	return fmt.Sprintf("block %s is quarantined", e.cid)
+}
+
+// guardQuarantine is the check read paths run before touching a block.
+func (s *Server) guardQuarantine(c cid.Cid) error {
+	if s.Quarantine.has(c) {
+		return &quarantinedError{cid: c}
+	}
+	return nil
+}
+
+// respondQuarantine converts a quarantined-block error into the
+// tombstone response, passing every other error through.
+func (s *Server) respondQuarantine(c echo.Context, err error) error {
+	var qe *quarantinedError
+	if errors.As(err, &qe) {
+		return s.quarantineTombstone(c, qe.cid)
+	}
+	return err
+}
+
+// readStore is the blockstore read paths should fetch through: it
+// refuses quarantined blocks so a listing or walk cant leak bytes an
+// operator pulled from circulation.
+func (s *Server) readStore() blockstore.Blockstore {
+	return &quarantineBs{Blockstore: s.Blockstore, s: s}
+}
+
+type quarantineBs struct {
+	blockstore.Blockstore
+	s *Server
+}
+
+func (q *quarantineBs) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if err := q.s.guardQuarantine(c); err != nil {
+		return nil, err
+	}
+	return q.Blockstore.Get(ctx, c)
+}
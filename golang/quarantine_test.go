@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestBlockQuarantine(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	nd, err := cbornode.WrapObject(map[string]string{"secret": "contraband"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	gatewayGet := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+		c.SetParamNames("cid", "*")
+		c.SetParamValues(nd.Cid().String(), "")
+		if err := s.handleIpfsGet(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	if rec := gatewayGet(); !strings.Contains(rec.Body.String(), "contraband") {
+		t.Fatalf("block should serve before quarantine: %s", rec.Body.String())
+	}
+
+	// quarantine it
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("POST", "/", nil), rec)
+	c.SetParamNames("cid")
+	c.SetParamValues(nd.Cid().String())
+	if err := s.handleQuarantineBlock(c); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = gatewayGet()
+	if rec.Code != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("expected a tombstone status, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "contraband") {
+		t.Fatal("quarantined bytes must not be served")
+	}
+
+	// update walks refuse the block too
+	err = s.graphWalkRec(ctx, nd.Cid(), s.Blockstore, 1, &walkStats{})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("expected the walk to refuse a quarantined block, got %v", err)
+	}
+
+	// and it comes back after unquarantine
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest("POST", "/", nil), rec)
+	c.SetParamNames("cid")
+	c.SetParamValues(nd.Cid().String())
+	if err := s.handleUnquarantineBlock(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec := gatewayGet(); !strings.Contains(rec.Body.String(), "contraband") {
+		t.Fatal("block should serve again after unquarantine")
+	}
+}
+
+func TestQuarantineCoversUserReads(t *testing.T) {
+	s := testServer(t)
+	putTestUser(t, s, "alice", []string{"a"})
+
+	// quarantine the post record putTestUser stored
+	post, err := cbornode.WrapObject(map[string]string{"stub": "post"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Quarantine.add(post.Cid())
+
+	e := echo.New()
+	get := func(target string, h echo.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest("GET", target, nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues("alice")
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	if rec := get("/", s.handleGetUser); rec.Code != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("full download should tombstone, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/?partial=true", s.handleGetUser); rec.Code != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("partial download should tombstone, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/", s.handleListPosts); rec.Code != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("posts listing should tombstone, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	s.Quarantine.remove(post.Cid())
+	if rec := get("/", s.handleGetUser); rec.Code != http.StatusOK {
+		t.Fatalf("download should work again after unquarantine, got %d", rec.Code)
+	}
+}
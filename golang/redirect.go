@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// plaintextHandler is what the optional plaintext listener serves when
+// tls is running elsewhere. In "redirect" mode requests get a 301 to
+// the https equivalent with path and query preserved, in "refuse" mode
+// they get a 426. Pure plaintext dev mode simply doesnt mount this.
+func plaintextHandler(mode string, tlsHost string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mode == "refuse" {
+			http.Error(w, "upgrade required: this server only speaks https", http.StatusUpgradeRequired)
+			return
+		}
+
+		host := tlsHost
+		if host == "" {
+			host = r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+		}
+
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
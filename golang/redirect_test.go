@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlaintextRedirect(t *testing.T) {
+	h := plaintextHandler("redirect", "")
+
+	req := httptest.NewRequest("GET", "http://example.com:2582/user/alice?since=abc", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != 301 {
+		t.Fatalf("expected 301, got %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "https://example.com/user/alice?since=abc" {
+		t.Fatalf("unexpected redirect location: %s", loc)
+	}
+}
+
+func TestPlaintextRefuse(t *testing.T) {
+	h := plaintextHandler("refuse", "")
+
+	req := httptest.NewRequest("GET", "http://example.com/user/alice", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != 426 {
+		t.Fatalf("expected 426, got %d", rec.Code)
+	}
+}
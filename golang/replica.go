@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	blockservice "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	merkledag "github.com/ipfs/go-merkledag"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// Replicator mirrors commits from a primary into a local (read-only)
+// server. It follows the primary's root log and pulls each updated
+// user's car, so a replica can serve reads for state committed
+// elsewhere.
+type Replicator struct {
+	S *Server
+
+	// Primary is the base url of the server to replicate from.
+	Primary string
+
+	// FetchUser pulls a user's current car from the primary. Overridable
+	// for tests, defaults to GET {primary}/user/{id}.
+	FetchUser func(ctx context.Context, user string) (io.ReadCloser, error)
+
+	// RetryDelay is the base wait between attempts at a failing commit,
+	// growing linearly with consecutive failures. Overridable for tests,
+	// defaults to one second.
+	RetryDelay time.Duration
+}
+
+func NewReplicator(s *Server, primary string) *Replicator {
+	r := &Replicator{S: s, Primary: primary, RetryDelay: time.Second}
+	r.FetchUser = func(ctx context.Context, user string) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", primary+"/user/"+user, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("primary returned %d for user %s", resp.StatusCode, user)
+		}
+		return resp.Body, nil
+	}
+	return r
+}
+
+// Apply mirrors a single commit event locally.
+func (r *Replicator) Apply(ctx context.Context, ev *Event) error {
+	body, err := r.FetchUser(ctx, ev.User)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmpbs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	ch, _, err := loadCarBlocks(ctx, tmpbs, body, nil)
+	if err != nil {
+		return err
+	}
+	if len(ch.Roots) != 1 {
+		return fmt.Errorf("expected one root from primary, got %d", len(ch.Roots))
+	}
+
+	if _, err := Copy(ctx, tmpbs, r.S.Blockstore, ch.Roots[0]); err != nil {
+		return err
+	}
+
+	// mirrored state bypasses the read-only guard, its the primary's
+	// writes we are applying
+	r.S.ulk.Lock()
+	r.S.UserRoots[ev.User] = ch.Roots[0]
+	if _, ok := r.S.UserDids[ev.User]; !ok {
+		r.S.UserDids[ev.User] = ""
+	}
+	r.S.ulk.Unlock()
+	r.S.RootLog.Append(ev.User, ch.Roots[0])
+	return nil
+}
+
+// maxApplyAttempts is how many times one commit is retried before the
+// replica skips it and moves on. Halting replication forever on a
+// single bad entry would be worse than a gap the next full sync heals.
+const maxApplyAttempts = 5
+
+// Run follows the primary's root log from the beginning, applying each
+// commit as it appears. Polling /log/:seq stands in for driving this
+// off the /subscribe firehose; it is cruder but restartable from any
+// seq without cursor bookkeeping.
+func (r *Replicator) Run(ctx context.Context) {
+	var next int64
+	var failures int
+	for {
+		ev, err := r.fetchLogEntry(ctx, next)
+		if err != nil {
+			// a pruned entry is gone for good, waiting for it would
+			// wedge replication at this seq forever
+			if errors.Is(err, errLogEntryPruned) {
+				fmt.Printf("replica: seq %d was pruned by the primary, skipping\n", next)
+				next++
+				continue
+			}
+			select {
+			case <-time.After(r.RetryDelay):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := r.Apply(ctx, ev); err != nil {
+			failures++
+			if failures >= maxApplyAttempts {
+				fmt.Printf("replica: skipping seq %d after %d failed attempts: %s\n", next, failures, err)
+				next++
+				failures = 0
+				continue
+			}
+			// back off before retrying the same seq, an apply that keeps
+			// failing must not spin the loop hot
+			select {
+			case <-time.After(time.Duration(failures) * r.RetryDelay):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		failures = 0
+		next++
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
+// errLogEntryPruned marks a seq the primary's retention has dropped for
+// good, as opposed to one that simply hasnt been published yet.
+var errLogEntryPruned = errors.New("log entry pruned by the primary")
+
+func (r *Replicator) fetchLogEntry(ctx context.Context, seq int64) (*Event, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/log/%d", r.Primary, seq), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, errLogEntryPruned
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("no entry at %d", seq)
+	}
+
+	var e rootLogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &Event{Seq: e.Seq, User: e.User, Root: e.Root}, nil
+}
+
+// writeCarFrom serializes a dag out of the given blockstore.
+func writeCarFrom(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, w io.Writer) error {
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	return car.WriteCar(ctx, ds, []cid.Cid{root}, w)
+}
+
+// writeCarBlocks dumps every block in the store as a car with the given
+// root, without traversing links. Unlike writeCarFrom it tolerates dags
+// with holes, which is exactly what partial downloads need.
+func writeCarBlocks(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, w io.Writer) error {
+	if err := car.WriteHeader(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	}, w); err != nil {
+		return err
+	}
+
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		blk, err := bs.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if err := carutil.LdWrite(w, k.Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCarTo serializes a user's dag, shared by the get handler and
+// replication tests.
+func (s *Server) writeCarTo(ctx context.Context, root cid.Cid, w io.Writer) error {
+	return writeCarFrom(ctx, s.Blockstore, root, w)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestReplicaMirrorsPrimary(t *testing.T) {
+	ctx := context.TODO()
+
+	primary := testServer(t)
+	replica := testServer(t)
+	replica.ReadOnly = true
+
+	root, carb := testUserCar(t, "alice", 0)
+	if err := primary.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReplicator(replica, "")
+	r.FetchUser = func(ctx context.Context, user string) (io.ReadCloser, error) {
+		buf := new(bytes.Buffer)
+		if err := primary.writeCarTo(ctx, primary.UserRoots[user], buf); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(buf), nil
+	}
+
+	if err := r.Apply(ctx, &Event{Seq: 0, User: "alice", Root: root}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replica now serves alice's state
+	u, err := replica.getUserObj(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "alice" {
+		t.Fatalf("replica served wrong user: %+v", u)
+	}
+
+	// but refuses writes
+	e := echo.New()
+	req := httptest.NewRequest("POST", "/update", bytes.NewReader(carb))
+	rec := httptest.NewRecorder()
+	err = replica.handleUserUpdate(e.NewContext(req, rec))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 403 {
+		t.Fatalf("expected the replica to refuse writes with 403, got %v", err)
+	}
+}
+
+func TestReplicaSkipsPoisonCommit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	primary := testServer(t)
+	replica := testServer(t)
+	replica.ReadOnly = true
+
+	root, carb := testUserCar(t, "alice", 0)
+	if err := primary.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the primary's log holds a poison entry at seq 0 and a good one at 1
+	log := []rootLogEntry{
+		{Seq: 0, User: "poison", Root: root},
+		{Seq: 1, User: "alice", Root: root},
+	}
+	applied := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var seq int
+		if _, err := fmt.Sscanf(req.URL.Path, "/log/%d", &seq); err != nil || seq >= len(log) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(log[seq])
+	}))
+	defer srv.Close()
+
+	r := NewReplicator(replica, srv.URL)
+	r.RetryDelay = time.Millisecond
+	r.FetchUser = func(ctx context.Context, user string) (io.ReadCloser, error) {
+		if user == "poison" {
+			return nil, fmt.Errorf("primary refuses to serve %s", user)
+		}
+		buf := new(bytes.Buffer)
+		if err := primary.writeCarTo(ctx, primary.UserRoots[user], buf); err != nil {
+			return nil, err
+		}
+		close(applied)
+		return io.NopCloser(buf), nil
+	}
+
+	go r.Run(ctx)
+
+	select {
+	case <-applied:
+	case <-ctx.Done():
+		t.Fatal("replication never got past the poison commit")
+	}
+}
+
+func TestReplicaSkipsPrunedHead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	primary := testServer(t)
+	replica := testServer(t)
+	replica.ReadOnly = true
+
+	root, carb := testUserCar(t, "alice", 0)
+	if err := primary.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	// retention already pruned seq 0, the surviving entry is at 1. A
+	// replica started this late must advance past the pruned head.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/log/0":
+			w.WriteHeader(http.StatusGone)
+		case "/log/1":
+			json.NewEncoder(w).Encode(rootLogEntry{Seq: 1, User: "alice", Root: root})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	applied := make(chan struct{})
+	r := NewReplicator(replica, srv.URL)
+	r.RetryDelay = time.Millisecond
+	r.FetchUser = func(ctx context.Context, user string) (io.ReadCloser, error) {
+		buf := new(bytes.Buffer)
+		if err := primary.writeCarTo(ctx, primary.UserRoots[user], buf); err != nil {
+			return nil, err
+		}
+		close(applied)
+		return io.NopCloser(buf), nil
+	}
+
+	go r.Run(ctx)
+
+	select {
+	case <-applied:
+	case <-ctx.Done():
+		t.Fatal("replication never got past the pruned entry")
+	}
+}
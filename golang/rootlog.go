@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrPrunedRoot is returned for log entries that retention already threw
+// away.
+var ErrPrunedRoot = errors.New("root log entry has been pruned")
+
+type rootLogEntry struct {
+	Seq  int64     `json:"seq"`
+	User string    `json:"user"`
+	Root cid.Cid   `json:"root"`
+	Time time.Time `json:"time"`
+}
+
+// RootLog is an append-only log of every root update the server commits.
+// Without retention it grows without bound, so it can be capped by entry
+// count, by age, or both.
+type RootLog struct {
+	lk      sync.Mutex
+	entries []rootLogEntry
+	nextSeq int64
+
+	// MaxEntries caps the number of retained entries, zero means no cap.
+	MaxEntries int
+	// MaxAge drops entries older than this, zero means keep forever.
+	MaxAge time.Duration
+
+	pruned int64
+}
+
+func (l *RootLog) Append(user string, root cid.Cid) int64 {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	seq := l.nextSeq
+	l.nextSeq++
+	l.entries = append(l.entries, rootLogEntry{
+		Seq:  seq,
+		User: user,
+		Root: root,
+		Time: time.Now(),
+	})
+	return seq
+}
+
+func (l *RootLog) Get(seq int64) (*rootLogEntry, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	if seq < 0 || seq >= l.nextSeq {
+		return nil, fmt.Errorf("no such root log entry: %d", seq)
+	}
+
+	first := l.nextSeq - int64(len(l.entries))
+	if seq < first {
+		return nil, ErrPrunedRoot
+	}
+
+	e := l.entries[seq-first]
+	return &e, nil
+}
+
+// Prune applies the retention policy once, returning how many entries it
+// dropped.
+func (l *RootLog) Prune() int {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	keep := l.entries
+	if l.MaxAge > 0 {
+		cutoff := time.Now().Add(-l.MaxAge)
+		for len(keep) > 0 && keep[0].Time.Before(cutoff) {
+			keep = keep[1:]
+		}
+	}
+	if l.MaxEntries > 0 && len(keep) > l.MaxEntries {
+		keep = keep[len(keep)-l.MaxEntries:]
+	}
+
+	n := len(l.entries) - len(keep)
+	if n > 0 {
+		l.entries = append([]rootLogEntry{}, keep...)
+		atomic.AddInt64(&l.pruned, int64(n))
+	}
+	return n
+}
+
+// PrunedCount reports how many entries retention has dropped so far.
+func (l *RootLog) PrunedCount() int64 {
+	return atomic.LoadInt64(&l.pruned)
+}
+
+func (l *RootLog) runPruner(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			l.Prune()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleGetRootLogEntry serves time-travel reads of old roots. Entries
+// that retention dropped come back as 410 so clients know they are gone
+// for good rather than simply missing.
+func (s *Server) handleGetRootLogEntry(c echo.Context) error {
+	seq, err := strconv.ParseInt(c.Param("seq"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid seq")
+	}
+
+	e, err := s.RootLog.Get(seq)
+	if err != nil {
+		if errors.Is(err, ErrPrunedRoot) {
+			return echo.NewHTTPError(http.StatusGone, err.Error())
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, e)
+}
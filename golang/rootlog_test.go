@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestRootLogRetentionByCount(t *testing.T) {
+	l := &RootLog{MaxEntries: 10}
+
+	for i := 0; i < 50; i++ {
+		l.Append("alice", cid.Undef)
+	}
+
+	if n := l.Prune(); n != 40 {
+		t.Fatalf("expected 40 entries pruned, got %d", n)
+	}
+	if l.PrunedCount() != 40 {
+		t.Fatalf("pruned counter wrong: %d", l.PrunedCount())
+	}
+
+	if _, err := l.Get(5); !errors.Is(err, ErrPrunedRoot) {
+		t.Fatalf("expected pruned entry, got %v", err)
+	}
+	if e, err := l.Get(45); err != nil || e.Seq != 45 {
+		t.Fatalf("expected retained entry 45, got %v %v", e, err)
+	}
+}
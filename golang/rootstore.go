@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// root store operations arent tied to any one request
+var bgctx = context.Background()
+
+// RootStore persists the user -> root mapping so a restart doesnt wipe
+// the registry while the blockstore survives on disk.
+type RootStore interface {
+	Get(user string) (cid.Cid, error)
+	Put(user string, root cid.Cid) error
+	Delete(user string) error
+	List() (map[string]cid.Cid, error)
+}
+
+// DidStore is the optional companion for persisting the user -> did
+// mapping alongside the roots.
+type DidStore interface {
+	GetDid(user string) (string, error)
+	PutDid(user, did string) error
+	DeleteDid(user string) error
+	ListDids() (map[string]string, error)
+}
+
+var errRootNotFound = fmt.Errorf("root not found")
+
+const (
+	rootKeyPrefix = "/roots/"
+	didKeyPrefix  = "/dids/"
+)
+
+// dsRootStore is the default RootStore, backed by a go-datastore so any
+// disk-backed datastore gives durable roots for free.
+type dsRootStore struct {
+	lk sync.Mutex
+	ds datastore.Datastore
+}
+
+func NewDsRootStore(ds datastore.Datastore) *dsRootStore {
+	return &dsRootStore{ds: ds}
+}
+
+func (r *dsRootStore) Get(user string) (cid.Cid, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	data, err := r.ds.Get(bgctx, datastore.NewKey(rootKeyPrefix+user))
+	if err != nil {
+		return cid.Undef, errRootNotFound
+	}
+	if len(data) == 0 {
+		// registered but nothing committed yet
+		return cid.Undef, nil
+	}
+	return cid.Cast(data)
+}
+
+func (r *dsRootStore) Put(user string, root cid.Cid) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	var data []byte
+	if root.Defined() {
+		data = root.Bytes()
+	}
+	return r.ds.Put(bgctx, datastore.NewKey(rootKeyPrefix+user), data)
+}
+
+func (r *dsRootStore) Delete(user string) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.ds.Delete(bgctx, datastore.NewKey(rootKeyPrefix+user))
+}
+
+func (r *dsRootStore) List() (map[string]cid.Cid, error) {
+	return listCids(r, rootKeyPrefix)
+}
+
+func (r *dsRootStore) GetDid(user string) (string, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	data, err := r.ds.Get(bgctx, datastore.NewKey(didKeyPrefix+user))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (r *dsRootStore) PutDid(user, did string) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.ds.Put(bgctx, datastore.NewKey(didKeyPrefix+user), []byte(did))
+}
+
+func (r *dsRootStore) DeleteDid(user string) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.ds.Delete(bgctx, datastore.NewKey(didKeyPrefix+user))
+}
+
+func (r *dsRootStore) ListDids() (map[string]string, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	res, err := r.ds.Query(bgctx, dsq.Query{Prefix: strings.TrimSuffix(didKeyPrefix, "/")})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := make(map[string]string)
+	for ent := range res.Next() {
+		if ent.Error != nil {
+			return nil, ent.Error
+		}
+		out[strings.TrimPrefix(ent.Key, didKeyPrefix)] = string(ent.Value)
+	}
+	return out, nil
+}
+
+func listCids(r *dsRootStore, prefix string) (map[string]cid.Cid, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	res, err := r.ds.Query(bgctx, dsq.Query{Prefix: strings.TrimSuffix(prefix, "/")})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := make(map[string]cid.Cid)
+	for ent := range res.Next() {
+		if ent.Error != nil {
+			return nil, ent.Error
+		}
+		name := strings.TrimPrefix(ent.Key, prefix)
+		if len(ent.Value) == 0 {
+			out[name] = cid.Undef
+			continue
+		}
+		c, err := cid.Cast(ent.Value)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = c
+	}
+	return out, nil
+}
+
+// loadPersistedUsers hydrates the in-memory maps from the root store at
+// startup.
+func (s *Server) loadPersistedUsers() error {
+	if s.Roots == nil {
+		return nil
+	}
+
+	roots, err := s.Roots.List()
+	if err != nil {
+		return err
+	}
+
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+	for name, root := range roots {
+		s.UserRoots[name] = root
+	}
+
+	if ds, ok := s.Roots.(DidStore); ok {
+		dids, err := ds.ListDids()
+		if err != nil {
+			return err
+		}
+		for name, did := range dids {
+			s.UserDids[name] = did
+		}
+	}
+	return nil
+}
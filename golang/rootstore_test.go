@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func TestRootsSurviveRestart(t *testing.T) {
+	ctx := context.TODO()
+
+	// the "disk" both server generations share
+	ds := dsync.MutexWrap(datastore.NewMapDatastore())
+
+	s1 := testServer(t)
+	s1.Blockstore = blockstore.NewBlockstore(ds)
+	s1.Roots = NewDsRootStore(ds)
+
+	if err := s1.putNewUser("alice", "did:key:z6MkAlice"); err != nil {
+		t.Fatal(err)
+	}
+	root, carb := testUserCar(t, "alice", 0)
+	if err := s1.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh server process over the same datastore
+	s2 := testServer(t)
+	s2.Blockstore = blockstore.NewBlockstore(ds)
+	s2.Roots = NewDsRootStore(ds)
+	if err := s2.loadPersistedUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s2.getUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != root {
+		t.Fatalf("expected the committed root after restart, got %s", got)
+	}
+	if s2.UserDids["alice"] != "did:key:z6MkAlice" {
+		t.Fatalf("did mapping lost across restart: %q", s2.UserDids["alice"])
+	}
+
+	// unknown users fail the same way they always have
+	_, err = s2.getUser("nobody")
+	if err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Fatalf("expected the usual no-such-user error, got %v", err)
+	}
+}
@@ -0,0 +1,1527 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+	multibase "github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/qri-io/ucan"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+type Server struct {
+	Blockstore blockstore.Blockstore
+	UserRoots  map[string]cid.Cid
+	UserDids   map[string]string
+	Updates    *updateQueue
+	RootLog    *RootLog
+	Events     *EventBus
+	Resolver   *DidResolver
+	Ucans      *UcanStore
+
+	// Keys caches resolved signing keys for signed-root verification.
+	// Nil falls back to resolving on every check.
+	Keys *KeyCache
+
+	// Key, when set, is this server's own signing identity. Its did is
+	// the audience for signed roots and capability tokens; unset servers
+	// keep the legacy shared TwitterDid identity.
+	Key *ServerKey
+
+	// Walks, when set, records histograms of update dag shapes.
+	Walks *WalkMetrics
+
+	// Metrics, when set, is the counter registry behind /metrics.
+	Metrics *Metrics
+
+	// Quarantine holds blocks pulled from circulation by an operator.
+	Quarantine quarantineSet
+
+	// Roots, when set, persists the user maps across restarts. The
+	// in-memory maps stay authoritative at runtime, every change is
+	// written through.
+	Roots RootStore
+
+	// Collections maps collection names to their on-disk representation,
+	// anything unlisted defaults to a hamt.
+	Collections map[string]CollectionDef
+
+	// AdminClientCAs, when set, requires admin requests to present a
+	// client certificate chaining to one of these CAs.
+	AdminClientCAs *x509.CertPool
+
+	// TrustedIntroducer, with IntroducerKey, lets a separate identity
+	// service vouch for (handle, did) registrations.
+	TrustedIntroducer string
+	IntroducerKey     ed25519.PublicKey
+
+	// MaxHandlesPerDid caps how many handles one did may hold, zero
+	// means the default of one.
+	MaxHandlesPerDid int
+
+	// AllowedHandleSuffixes restricts handles to the given domains (eg
+	// ".example.com"), empty means any handle is fine.
+	AllowedHandleSuffixes []string
+
+	// MaxWalkBlocks caps the total number of blocks walked while
+	// validating one update, zero means the default.
+	MaxWalkBlocks int
+
+	// MaxGraphDepth caps how deeply nested an update's dag may be, zero
+	// means the default.
+	MaxGraphDepth int
+
+	// MaxBlobBytes caps the size of any raw-codec blob a record may
+	// reference, zero means the default.
+	MaxBlobBytes int64
+
+	// MaxUpdateBytes caps the total size of one uploaded car, zero
+	// means the default.
+	MaxUpdateBytes int64
+
+	// MaxCollectionsPerUser caps how many collections one user may
+	// hold, zero means the default.
+	MaxCollectionsPerUser int
+
+	// CarOrderPolicy decides what happens when an uploaded car lists
+	// blocks out of topological order: ignore (default), warn or reject.
+	CarOrderPolicy string
+
+	// DefaultPageSize and MaxPageSize govern the limit param on all
+	// listing endpoints, zero means the built-in defaults.
+	DefaultPageSize int
+	MaxPageSize     int
+
+	// ListFailFast aborts listing walks on the first undecodable entry
+	// instead of skipping and reporting it.
+	ListFailFast bool
+
+	// ValidatePosts enforces the Post schema on the posts collection
+	// during update validation, unless a custom Collections entry
+	// overrides it. Off by default, clients have shipped free-form maps.
+	ValidatePosts bool
+
+	// StrictNumbers refuses to surface cbor integers that cant be
+	// represented exactly in json output, instead of passing through a
+	// possibly-rounded float.
+	StrictNumbers bool
+
+	// PreCommit, when set, can veto validated updates before they
+	// commit. PreCommitTimeout bounds how long it may take and
+	// PreCommitStatus is the response code for a veto, defaulting to 403.
+	PreCommit        PreCommitHook
+	PreCommitTimeout time.Duration
+	PreCommitStatus  int
+
+	// Logger receives structured operation logs, the process-wide slog
+	// default when nil.
+	Logger *slog.Logger
+
+	// Clock supplies the current time for token validity checks, real
+	// time when nil. Tests inject a fixed clock to simulate expiry.
+	Clock func() time.Time
+
+	// Debug makes decode errors include a hex dump of the offending
+	// bytes. Leave this off in production, it leaks stored data into
+	// error responses.
+	Debug bool
+
+	// ReadOnly marks this server as a replica, all write endpoints are
+	// refused and state arrives via replication instead.
+	ReadOnly bool
+
+	// ServerInit enables the alternate registration flow where the
+	// server bootstraps a new user's repo (empty posts hamt, did from
+	// the token issuer) instead of waiting for the client's first car.
+	ServerInit bool
+
+	// MaxStoreBytes caps the total size of stored blocks, zero means
+	// unbounded. StorePolicy picks what happens at the cap: "reject"
+	// (default) refuses updates with a 507, "evict" drops the least
+	// recently served users to make room.
+	MaxStoreBytes int64
+	StorePolicy   string
+
+	// HistoryDepth bounds how many prior roots are kept per user for
+	// time travel, zero keeps everything.
+	HistoryDepth int
+
+	storeBytes int64
+	servedLk   sync.Mutex
+	lastServed map[string]time.Time
+
+	// MaxConcurrentUpdates caps how many updates may validate at once,
+	// zero means the default.
+	MaxConcurrentUpdates int
+	activeUpdates        int64
+
+	// SunsetDate overrides when the deprecated routes will be removed,
+	// in http date format. Empty means the built-in default.
+	SunsetDate string
+
+	// CidBase forces a multibase for every cid rendered in a response,
+	// zero keeps each cid's canonical string form.
+	CidBase multibase.Encoding
+
+	// hlk guards history and gcEligible. Commits for different users
+	// run concurrently, and history reads and deletes race them.
+	hlk        sync.Mutex
+	history    map[string][]cid.Cid
+	gcEligible []cid.Cid
+
+	commitLocks userLocks
+
+	// ulk guards UserRoots and UserDids. Registration is a check-then-
+	// insert, so without it two simultaneous claims of the same name can
+	// both succeed (and concurrent map writes panic outright).
+	ulk sync.Mutex
+
+	legacyLk   sync.Mutex
+	legacyHits map[string]int64
+}
+
+type registerBody struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) handleRegister(c echo.Context) error {
+	if s.ReadOnly {
+		return echo.NewHTTPError(http.StatusForbidden, "this server is a read-only replica")
+	}
+
+	var body registerBody
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return err
+	}
+
+	// claiming a handle needs an explicit REGISTER grant for that name,
+	// a merely well-signed token (or one that can only post) wont do
+	att := ucan.Attenuation{
+		Cap: twitterCaps.Cap("REGISTER"),
+		Rsc: newAccountRsc("twitter", body.Name),
+	}
+	tok, err := s.checkTokenCap(c.Request(), att)
+	if err != nil {
+		return err
+	}
+
+	// the token's issuer is the identity claiming this handle, record it
+	// so the account is keyed by did from day one
+	if err := s.putNewUser(body.Name, tok.Issuer.String()); err != nil {
+		return err
+	}
+	s.logOp(c.Request().Context(), "user registered",
+		"user", body.Name, "did", tok.Issuer.String())
+
+	return c.String(http.StatusOK, "registered")
+}
+
+// putNewUser records a registration in both user maps. All validation
+// happens up front so a failure never leaves a half-entry (a did without
+// a root, or vice versa) behind.
+func (s *Server) putNewUser(name, did string) error {
+	name, err := normalizeHandle(name)
+	if err != nil {
+		return err
+	}
+	if err := validateHandle(name); err != nil {
+		return err
+	}
+
+	// the existence check and the insert have to be one atomic step, or
+	// two racing registrations of the same name both win
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+
+	if _, ok := s.UserRoots[name]; ok {
+		return fmt.Errorf("%w: user already registered: %s", ErrConflict, name)
+	}
+	if _, ok := s.UserDids[name]; ok {
+		return fmt.Errorf("%w: user already registered: %s", ErrConflict, name)
+	}
+
+	if err := s.checkHandleSuffix(name); err != nil {
+		return err
+	}
+
+	if did != "" && s.handlesForDid(did) >= s.maxHandlesPerDid() {
+		return echo.NewHTTPError(http.StatusForbidden,
+			fmt.Sprintf("did %s already holds the maximum number of handles", did))
+	}
+
+	s.UserRoots[name] = cid.Undef
+	s.UserDids[name] = did
+
+	if s.Roots != nil {
+		if err := s.Roots.Put(name, cid.Undef); err != nil {
+			return err
+		}
+		if ds, ok := s.Roots.(DidStore); ok {
+			if err := ds.PutDid(name, did); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkHandleSuffix enforces the configured handle domains, servers
+// that own a domain can insist handles live under it.
+func (s *Server) checkHandleSuffix(handle string) error {
+	if len(s.AllowedHandleSuffixes) == 0 {
+		return nil
+	}
+
+	for _, suf := range s.AllowedHandleSuffixes {
+		if strings.HasSuffix(handle, suf) {
+			return nil
+		}
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest,
+		fmt.Sprintf("handle %q is not under an allowed domain", handle))
+}
+
+func (s *Server) maxHandlesPerDid() int {
+	if s.MaxHandlesPerDid > 0 {
+		return s.MaxHandlesPerDid
+	}
+	return 1
+}
+
+// handlesForDid counts how many handles a did currently holds. Callers
+// hold s.ulk.
+func (s *Server) handlesForDid(did string) int {
+	var n int
+	for _, d := range s.UserDids {
+		if d == did {
+			n++
+		}
+	}
+	return n
+}
+
+// reconcileUserMaps drops half-entries that a crashed or buggy earlier
+// run may have left behind, returning the names it cleaned up. Run this
+// at startup before serving traffic.
+func (s *Server) reconcileUserMaps() []string {
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+
+	var cleaned []string
+	for name := range s.UserDids {
+		if _, ok := s.UserRoots[name]; !ok {
+			delete(s.UserDids, name)
+			cleaned = append(cleaned, name)
+		}
+	}
+	for name := range s.UserRoots {
+		if _, ok := s.UserDids[name]; !ok {
+			delete(s.UserRoots, name)
+			cleaned = append(cleaned, name)
+		}
+	}
+	return cleaned
+}
+
+// handleRegisterUserAlt is the alternate, server-init registration flow:
+// instead of the client bringing their own signed car later, the server
+// constructs the initial user object over an empty posts hamt, commits
+// it, and streams the resulting dag back as a car for the client to
+// build on. Off by default, enable with -server-init.
+func (s *Server) handleRegisterUserAlt(c echo.Context) error {
+	if !s.ServerInit {
+		return echo.NewHTTPError(http.StatusNotImplemented,
+			"server-side registration is disabled, start with -server-init to enable it")
+	}
+	if s.ReadOnly {
+		return echo.NewHTTPError(http.StatusForbidden, "this server is a read-only replica")
+	}
+	ctx := c.Request().Context()
+
+	var body registerBody
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return err
+	}
+
+	// same bar as the normal flow: claiming the handle needs an explicit
+	// REGISTER grant for it
+	att := ucan.Attenuation{
+		Cap: twitterCaps.Cap("REGISTER"),
+		Rsc: newAccountRsc("twitter", body.Name),
+	}
+	tok, err := s.checkTokenCap(c.Request(), att)
+	if err != nil {
+		return err
+	}
+	did := tok.Issuer.String()
+
+	name, err := normalizeHandle(body.Name)
+	if err != nil {
+		return err
+	}
+	if err := s.putNewUser(name, did); err != nil {
+		return err
+	}
+
+	root, err := s.initUserRepo(ctx, name, did)
+	if err != nil {
+		return err
+	}
+	s.updateUserRoot(name, root)
+
+	return writeCarFrom(ctx, s.Blockstore, root, c.Response())
+}
+
+// initUserRepo writes the minimal repo for a fresh user, an empty posts
+// hamt under a user object carrying the registered did, and returns the
+// new root.
+func (s *Server) initUserRepo(ctx context.Context, name, did string) (cid.Cid, error) {
+	cst := cbornode.NewCborStore(s.Blockstore)
+	postsRoot, err := cst.Put(ctx, hamt.NewNode(cst))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	nd, err := cbornode.WrapObject(&User{
+		DID:       did,
+		Name:      name,
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := s.Blockstore.Put(ctx, nd); err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+func (s *Server) handleUserUpdate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if s.ReadOnly {
+		return echo.NewHTTPError(http.StatusForbidden, "this server is a read-only replica")
+	}
+
+	// the capability has to name the account actually being updated,
+	// which we only know once the car is parsed, so the check runs as a
+	// callback after decode
+	checkUser := func(name string) error {
+		att := ucan.Attenuation{
+			Cap: twitterCaps.Cap("POST"),
+			Rsc: newAccountRsc("twitter", name),
+		}
+		tok, err := s.checkTokenCap(c.Request(), att)
+		if err != nil {
+			return err
+		}
+
+		// a third-party client presents a delegation chain rooted in the
+		// user's own grant; whoever sits at the top has to be the did
+		// registered for the account
+		rootDid, err := s.verifyDelegationChain(ctx, c.Request(), tok)
+		if err != nil {
+			return err
+		}
+		if registered, ok := s.lookupDid(name); ok && registered != "" && rootDid != registered {
+			return echo.NewHTTPError(http.StatusForbidden,
+				fmt.Sprintf("delegation root %s is not the did registered for %s", rootDid, name))
+		}
+		return nil
+	}
+
+	release, err := s.beginUpdate()
+	if err != nil {
+		return err
+	}
+	defer release()
+	c.Response().Header().Set(LoadHeader, s.loadHeader())
+
+	// a dry run validates everything and reports every failure at once,
+	// committing nothing
+	if c.QueryParam("dry-run") == "true" {
+		data, err := io.ReadAll(s.limitUpdateBody(c.Request().Body))
+		if err != nil {
+			return err
+		}
+		root, tmpbs, err := parseAndValidateCAR(data)
+		if err != nil {
+			return c.JSON(http.StatusOK, validationReport{
+				Errors: []validationError{{Code: "bad_car", Message: err.Error()}},
+			})
+		}
+		if u, _, err := s.decodeUserRoot(ctx, tmpbs, root); err == nil {
+			if err := checkUser(u.Name); err != nil {
+				return err
+			}
+		}
+		errs := s.validateAll(ctx, tmpbs, root)
+		return c.JSON(http.StatusOK, validationReport{Valid: len(errs) == 0, Errors: errs})
+	}
+
+	// for very large updates the client can ask us to process the car in
+	// the background instead of holding the connection open
+	if c.QueryParam("async") == "true" {
+		buf, err := io.ReadAll(s.limitUpdateBody(c.Request().Body))
+		if err != nil {
+			return err
+		}
+
+		// authorize before the job is accepted, the worker has no
+		// request to check against
+		root, tmpbs, err := parseAndValidateCAR(buf)
+		if err != nil {
+			return err
+		}
+		u, _, err := s.decodeUserRoot(ctx, tmpbs, root)
+		if err != nil {
+			return err
+		}
+		if err := checkUser(u.Name); err != nil {
+			return err
+		}
+
+		job, err := s.enqueueUpdate(buf)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusAccepted, map[string]string{
+			"id":        job.ID,
+			"status":    job.Status,
+			"statusUrl": "/update/status/" + job.ID,
+		})
+	}
+
+	// long imports can ask for streamed progress frames
+	if c.QueryParam("progress") == "true" {
+		return s.runUpdateWithProgress(ctx, c, checkUser)
+	}
+
+	// clients removing posts declare the keys so we can catch deletes
+	// that dont line up with the previous tree
+	var deletes []string
+	if ds := c.QueryParam("deletes"); ds != "" {
+		deletes = strings.Split(ds, ",")
+	}
+
+	// a client can pin the root its update was based on, so a commit
+	// that raced past it surfaces as a conflict instead of a lost update
+	var prev *cid.Cid
+	if h := c.Request().Header.Get(PrevRootHeader); h != "" {
+		pc, err := cid.Decode(h)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("invalid %s header: %s", PrevRootHeader, err))
+		}
+		prev = &pc
+	}
+
+	if err := s.runUpdateDeclared(ctx, c.Request().Body, deletes, prev, checkUser); err != nil {
+		return err
+	}
+
+	return c.String(http.StatusOK, "ok")
+}
+
+// runUpdateWithProgress processes an upload while streaming ndjson
+// progress frames of processed block counts. A client disconnect
+// cancels the context and aborts before anything commits.
+func (s *Server) runUpdateWithProgress(ctx context.Context, c echo.Context, auth userAuthFn) error {
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	resp.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(resp)
+
+	tmpbs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	ch, carBytes, err := loadCarBlocks(ctx, tmpbs, s.limitUpdateBody(c.Request().Body), func(n int64) {
+		enc.Encode(map[string]int64{"processed": n})
+		resp.Flush()
+	})
+	if err != nil {
+		enc.Encode(map[string]string{"error": err.Error()})
+		return nil
+	}
+	if len(ch.Roots) != 1 {
+		enc.Encode(map[string]string{"error": "expected exactly one root in car file"})
+		return nil
+	}
+
+	if err := s.commitCar(ctx, tmpbs, ch.Roots[0], carBytes, nil, nil, auth); err != nil {
+		enc.Encode(map[string]string{"error": err.Error()})
+		return nil
+	}
+
+	return enc.Encode(map[string]interface{}{"done": true, "root": s.renderCid(ch.Roots[0])})
+}
+
+// runUpdate validates an uploaded car and, if everything checks out,
+// commits it as the user's new root.
+func (s *Server) runUpdate(ctx context.Context, r io.Reader) error {
+	return s.runUpdateDeclared(ctx, r, nil, nil, nil)
+}
+
+// defaultMaxUpdateBytes bounds how much of an update upload we are
+// willing to buffer, one oversized POST shouldnt be able to exhaust
+// server memory.
+const defaultMaxUpdateBytes = 16 << 20
+
+func (s *Server) maxUpdateBytes() int64 {
+	if s.MaxUpdateBytes > 0 {
+		return s.MaxUpdateBytes
+	}
+	return defaultMaxUpdateBytes
+}
+
+// runUpdateDeclared is runUpdate with the client's declared deletions
+// attached, for the paths that carry them.
+// userAuthFn authorizes an operation against the user it turned out to
+// target, once parsing has revealed who that is.
+type userAuthFn func(name string) error
+
+func (s *Server) runUpdateDeclared(ctx context.Context, r io.Reader, deletes []string, prev *cid.Cid, auth userAuthFn) error {
+	root, stage, carBytes, err := s.stageCarStream(ctx, r)
+	if err != nil {
+		return err
+	}
+	return s.commitCar(ctx, stage, root, carBytes, deletes, prev, auth)
+}
+
+// parseAndValidateCAR is the untrusted-input seam for update parsing.
+// It buffers the car into a fresh blockstore, insists on a single root
+// and checks the root block's codec, all without touching any server
+// state, which makes it directly fuzzable.
+func parseAndValidateCAR(data []byte) (cid.Cid, blockstore.Blockstore, error) {
+	ctx := context.Background()
+
+	tmpbs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	ch, _, err := loadCarBlocks(ctx, tmpbs, bytes.NewReader(data), nil)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if ch == nil || len(ch.Roots) != 1 {
+		return cid.Undef, nil, fmt.Errorf("expected exactly one root in car file")
+	}
+	root := ch.Roots[0]
+
+	blk, err := tmpbs.Get(ctx, root)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("car does not contain its own root: %w", err)
+	}
+	if err := verifyBlockCid(root, blk.RawData()); err != nil {
+		return cid.Undef, nil, err
+	}
+	if err := checkBlockCodec(root, blk.RawData()); err != nil {
+		return cid.Undef, nil, err
+	}
+
+	return root, tmpbs, nil
+}
+
+// commitCar runs all update validation over an already-buffered car and
+// commits it. Nothing in the main store changes until every check has
+// passed, so an aborted upload never leaves a partial commit.
+func (s *Server) commitCar(ctx context.Context, tmpbs blockstore.Blockstore, root cid.Cid, carBytes int64, deletes []string, prev *cid.Cid, auth userAuthFn) error {
+	if err := s.ensureStoreRoom(ctx, carBytes); err != nil {
+		return err
+	}
+
+	// in backpressure mode theres no point doing the work if we cant
+	// deliver the resulting event
+	if !s.Events.DropOnFull && s.Events.Saturated() {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "event bus saturated, retry later")
+	}
+
+	u, sr, err := s.decodeUserRoot(ctx, tmpbs, root)
+	if err != nil {
+		return err
+	}
+
+	// now that we know which account this update is for, the token has
+	// to actually cover it
+	if auth != nil {
+		if err := auth(u.Name); err != nil {
+			return err
+		}
+	}
+
+	// verify before anything touches the main store, a bad signature
+	// must not leave blocks behind
+	if sr != nil {
+		if err := s.verifySignedRoot(ctx, u, sr); err != nil {
+			return err
+		}
+	}
+
+	// one user's commits apply in order, other users proceed in parallel
+	unlock := s.commitLocks.lock(u.Name)
+	defer unlock()
+
+	// a signed root may declare how many posts it covers, catch roots
+	// whose metadata disagrees with the actual tree
+	if sr != nil && sr.PostCount > 0 {
+		n, err := s.countHamtEntries(ctx, tmpbs, u.PostsRoot)
+		if err != nil {
+			return err
+		}
+		if n != sr.PostCount {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("signed root declares %d posts but the tree holds %d", sr.PostCount, n))
+		}
+	}
+
+	// a block claiming a did other than the one registered for the handle
+	// would let one identity overwrite another's root
+	if registered, ok := s.lookupDid(u.Name); ok && registered != "" && u.DID != "" && registered != u.DID {
+		return echo.NewHTTPError(http.StatusForbidden,
+			fmt.Sprintf("block did %s does not match the did registered for %s", u.DID, u.Name))
+	}
+
+	prevCols := map[string]cid.Cid{}
+	if pr, _ := s.lookupRoot(u.Name); pr.Defined() {
+		if pu, _, err := s.decodeUserRoot(ctx, s.Blockstore, pr); err == nil {
+			prevCols = collectionRoots(pu)
+		}
+	}
+	if err := s.checkCollectionLimit(collectionRoots(u), prevCols); err != nil {
+		return err
+	}
+
+	if err := s.checkNextPostMonotonic(ctx, u); err != nil {
+		return err
+	}
+
+	if err := s.checkNextPostFree(ctx, tmpbs, u); err != nil {
+		return err
+	}
+
+	if err := s.validateDeclaredDeletes(ctx, tmpbs, u, deletes); err != nil {
+		return err
+	}
+
+	if err := s.checkCollectionRoot(ctx, tmpbs, u.PostsRoot, "posts"); err != nil {
+		return err
+	}
+
+	if err := s.validateCollectionRecords(ctx, tmpbs, u.PostsRoot, "posts"); err != nil {
+		return err
+	}
+
+	if err := s.ensureGraphWalkability(ctx, u, tmpbs); err != nil {
+		return err
+	}
+
+	if err := s.runPreCommitHook(ctx, u, root); err != nil {
+		return err
+	}
+
+	copied, err := Copy(ctx, tmpbs, s.Blockstore, root)
+	atomic.AddInt64(&s.storeBytes, copied)
+	if err != nil {
+		return err
+	}
+
+	// the copy and the root swap are separate steps, so re-verify the
+	// whole dag actually landed in the main store before the root moves.
+	// A lost write then surfaces as a failed update with the old root
+	// still served, never as a live root pointing at missing blocks.
+	seen := make(map[cid.Cid]bool)
+	if err := s.verifyCommittedDag(ctx, root, seen); err != nil {
+		return err
+	}
+
+	// the dag blocks the update actually shipped, as opposed to ones
+	// reused from an earlier commit, go out with the event so firehose
+	// consumers know what to fetch
+	var shipped []cid.Cid
+	for c := range seen {
+		if has, _ := tmpbs.Has(ctx, c); has {
+			shipped = append(shipped, c)
+		}
+	}
+	sort.Slice(shipped, func(i, j int) bool { return shipped[i].String() < shipped[j].String() })
+
+	if prev != nil {
+		if err := s.updateUserRootCAS(u.Name, *prev, root, shipped); err != nil {
+			return err
+		}
+	} else {
+		s.commitUserRoot(u.Name, root, shipped)
+	}
+
+	did, _ := s.lookupDid(u.Name)
+	s.logOp(ctx, "user update committed",
+		"user", u.Name, "did", did, "root", root.String(), "copied_bytes", copied)
+
+	s.Metrics.observeUpdate(copied)
+	return nil
+}
+
+// decodeErr wraps a cbor decode failure with the decode path, and in
+// debug mode a short hex dump of the failing bytes.
+func (s *Server) decodeErr(path string, data []byte, err error) error {
+	if !s.Debug {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	dump := data
+	if len(dump) > 64 {
+		dump = dump[:64]
+	}
+	return fmt.Errorf("decoding %s: %w (first bytes: %s)", path, err, hex.EncodeToString(dump))
+}
+
+// now is the server's clock, injectable for tests.
+func (s *Server) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// serverDid is the identity updates must be signed for: the configured
+// key's did, or the legacy shared identity when no key was loaded.
+func (s *Server) serverDid() string {
+	if s.Key != nil {
+		return s.Key.DID
+	}
+	return TwitterDid
+}
+
+// decodeUserRoot interprets an uploaded root block, which may be either
+// a SignedRoot wrapping the user object or (legacy) a bare user object.
+// When a SignedRoot is present, its audience must name this server so a
+// root signed for some other server cant be replayed here.
+func (s *Server) decodeUserRoot(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) (*User, *SignedRoot, error) {
+	blk, err := bs.Get(ctx, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkBlockCodec(root, blk.RawData()); err != nil {
+		return nil, nil, err
+	}
+
+	var sr SignedRoot
+	if err := cbornode.DecodeInto(blk.RawData(), &sr); err == nil && sr.User.Defined() {
+		if sr.Audience != s.serverDid() {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("signed root audience %q is not this server", sr.Audience))
+		}
+
+		ublk, err := bs.Get(ctx, sr.User)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkBlockCodec(sr.User, ublk.RawData()); err != nil {
+			return nil, nil, err
+		}
+
+		var u User
+		if err := cbornode.DecodeInto(ublk.RawData(), &u); err != nil {
+			return nil, nil, s.decodeErr("signedRoot/user", ublk.RawData(), err)
+		}
+		if err := checkUserRoundTrip(&u, sr.User); err != nil {
+			return nil, nil, err
+		}
+		return &u, &sr, nil
+	}
+
+	var u User
+	if err := cbornode.DecodeInto(blk.RawData(), &u); err != nil {
+		return nil, nil, s.decodeErr("user", blk.RawData(), err)
+	}
+	if err := checkUserRoundTrip(&u, root); err != nil {
+		return nil, nil, err
+	}
+	return &u, nil, nil
+}
+
+// checkUserRoundTrip re-encodes a decoded user and insists the result
+// lands back on the cid that claimed to hold it. The decoder happily
+// fills in zero values for fields a block never carried, so without
+// this a root could "decode" as a user out of bytes that describe
+// something else entirely.
+func checkUserRoundTrip(u *User, c cid.Cid) error {
+	nd, err := cbornode.WrapObject(u, mh.SHA2_256, -1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("block %s does not decode to a well-formed user: %s", c, err))
+	}
+	if nd.Cid() != c {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("block %s does not re-encode to the user it decodes as", c))
+	}
+	return nil
+}
+
+// MissingBlocksHeader lists the cids a partial download could not
+// include, comma separated.
+const MissingBlocksHeader = "X-Missing-Blocks"
+
+func (s *Server) handleGetUser(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	root, err := s.getUser(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	s.touchUser(c.Param("id"))
+
+	// the root cid names the entire repo state, which makes it a perfect
+	// etag: a client holding the current root has nothing to download
+	etag := `"` + s.renderCid(root) + `"`
+	c.Response().Header().Set("ETag", etag)
+	if match := c.Request().Header.Get("If-None-Match"); match != "" {
+		for _, cand := range strings.Split(match, ",") {
+			if strings.TrimSpace(cand) == etag {
+				return c.NoContent(http.StatusNotModified)
+			}
+		}
+	}
+
+	if since := c.QueryParam("since"); since != "" {
+		prev, err := cid.Decode(since)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid since cid")
+		}
+		return s.respondQuarantine(c, s.writeDiffUserCar(ctx, root, prev, c))
+	}
+
+	if c.QueryParam("partial") == "true" {
+		return s.respondQuarantine(c, s.writePartialUserCar(ctx, root, c))
+	}
+
+	// snapshot the dag up front so a gc or eviction that lands mid
+	// stream cant break the download
+	snap, err := s.snapshotDag(ctx, root)
+	if err != nil {
+		return s.respondQuarantine(c, err)
+	}
+
+	return writeCarFrom(ctx, snap, root, c.Response())
+}
+
+// writeDiffUserCar streams only the blocks reachable from root that a
+// client holding since doesnt already have, so catching up after a
+// small update doesnt mean re-downloading the whole repo. An unknown or
+// unrelated since just degrades to sending everything.
+func (s *Server) writeDiffUserCar(ctx context.Context, root, since cid.Cid, c echo.Context) error {
+	old := make(map[cid.Cid]bool)
+	if err := collectReachable(ctx, s.Blockstore, since, old); err != nil {
+		return err
+	}
+	cur := make(map[cid.Cid]bool)
+	if err := collectReachable(ctx, s.Blockstore, root, cur); err != nil {
+		return err
+	}
+
+	snap := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	for cc := range cur {
+		if old[cc] {
+			continue
+		}
+		if err := s.guardQuarantine(cc); err != nil {
+			return err
+		}
+		blk, err := s.Blockstore.Get(ctx, cc)
+		if err != nil {
+			return err
+		}
+		if err := snap.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+
+	return writeCarBlocks(ctx, snap, root, c.Response())
+}
+
+// writePartialUserCar is the best-effort variant of the user download:
+// every reachable-and-present block goes out, the holes are reported in
+// a header instead of failing the whole request.
+func (s *Server) writePartialUserCar(ctx context.Context, root cid.Cid, c echo.Context) error {
+	snap := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	var missing []cid.Cid
+	if err := pinPresent(ctx, s.readStore(), snap, root, &missing); err != nil {
+		return err
+	}
+
+	strs := make([]string, 0, len(missing))
+	for _, m := range missing {
+		strs = append(strs, s.renderCid(m))
+	}
+	c.Response().Header().Set(MissingBlocksHeader, strings.Join(strs, ","))
+
+	return writeCarBlocks(ctx, snap, root, c.Response())
+}
+
+// pinPresent is pinReachable for incomplete dags: missing blocks are
+// recorded instead of aborting the copy.
+func pinPresent(ctx context.Context, from, to blockstore.Blockstore, root cid.Cid, missing *[]cid.Cid) error {
+	blk, err := from.Get(ctx, root)
+	if err != nil {
+		// a quarantined block isnt a hole, the whole download tombstones
+		var qe *quarantinedError
+		if errors.As(err, &qe) {
+			return err
+		}
+		*missing = append(*missing, root)
+		return nil
+	}
+	if err := to.Put(ctx, blk); err != nil {
+		return err
+	}
+
+	if root.Prefix().Codec == cid.Raw {
+		return nil
+	}
+
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(l cid.Cid) {
+		links = append(links, l)
+	}); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		has, err := to.Has(ctx, l)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if err := pinPresent(ctx, from, to, l, missing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotDag pins everything reachable from root into a private
+// blockstore, giving readers a consistent view independent of the live
+// store.
+func (s *Server) snapshotDag(ctx context.Context, root cid.Cid) (blockstore.Blockstore, error) {
+	snap := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	if err := pinReachable(ctx, s.readStore(), snap, root); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// pinReachable copies every block reachable from root, raw leaves
+// included, unlike Copy which leaves raw blocks behind.
+func pinReachable(ctx context.Context, from, to blockstore.Blockstore, root cid.Cid) error {
+	blk, err := from.Get(ctx, root)
+	if err != nil {
+		return err
+	}
+	if err := to.Put(ctx, blk); err != nil {
+		return err
+	}
+
+	if root.Prefix().Codec == cid.Raw {
+		return nil
+	}
+
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(l cid.Cid) {
+		links = append(links, l)
+	}); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		has, err := to.Has(ctx, l)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if err := pinReachable(ctx, from, to, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGetSignedRoot returns the raw SignedRoot block for a user so
+// clients can verify the stored signature for themselves. Users whose
+// current root is a legacy unsigned user object get a 404.
+func (s *Server) handleGetSignedRoot(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	root, err := s.getUser(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	if err := s.guardQuarantine(root); err != nil {
+		return s.respondQuarantine(c, err)
+	}
+
+	blk, err := s.Blockstore.Get(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	var sr SignedRoot
+	if err := cbornode.DecodeInto(blk.RawData(), &sr); err != nil || !sr.User.Defined() {
+		return echo.NewHTTPError(http.StatusNotFound, "user's current root is not signed")
+	}
+
+	return c.Blob(http.StatusOK, "application/cbor", blk.RawData())
+}
+
+// getUser looks up a user's current root. The id here might be a name or
+// a did, we havent really decided yet.
+func (s *Server) getUser(id string) (cid.Cid, error) {
+	// lookups see the same canonical form registrations do, so nfd
+	// spellings of a registered handle still resolve
+	if normalized, err := normalizeHandle(id); err == nil {
+		id = normalized
+	}
+
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+
+	id = s.resolveUserLocked(id)
+
+	root, ok := s.UserRoots[id]
+	if ok {
+		return root, nil
+	}
+
+	// a cold cache after restart falls through to the persistent store
+	if s.Roots != nil {
+		root, err := s.Roots.Get(id)
+		if err == nil {
+			s.UserRoots[id] = root
+			return root, nil
+		}
+	}
+
+	return cid.Undef, fmt.Errorf("%w: no such user: %s", ErrUserNotFound, id)
+}
+
+// resolveUserLocked canonicalizes a name-or-did identifier to the
+// registered handle the user maps are keyed by. An id that looks like a
+// did resolves through the registration record; anything else is
+// already a handle. Callers hold s.ulk.
+func (s *Server) resolveUserLocked(id string) string {
+	if strings.HasPrefix(id, "did:") {
+		for name, did := range s.UserDids {
+			if did == id {
+				return name
+			}
+		}
+	}
+	return id
+}
+
+// resolveUser is resolveUserLocked for callers not holding the lock.
+func (s *Server) resolveUser(id string) string {
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+	return s.resolveUserLocked(id)
+}
+
+// lookupRoot reads a user's in-memory root under the user-map lock.
+func (s *Server) lookupRoot(name string) (cid.Cid, bool) {
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+	root, ok := s.UserRoots[name]
+	return root, ok
+}
+
+// lookupDid reads a user's registered did under the user-map lock.
+func (s *Server) lookupDid(name string) (string, bool) {
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+	did, ok := s.UserDids[name]
+	return did, ok
+}
+
+// userCount reports how many users are registered.
+func (s *Server) userCount() int {
+	s.ulk.Lock()
+	defer s.ulk.Unlock()
+	return len(s.UserRoots)
+}
+
+// PrevRootHeader carries the root a client based its update on, making
+// the commit a compare-and-swap.
+const PrevRootHeader = "X-Prev-Root"
+
+// updateUserRootCAS is updateUserRoot guarded by the root the client
+// based its update on, refusing the swap when another commit landed in
+// between. Callers hold the per-user commit lock, so check-then-swap
+// cant interleave.
+func (s *Server) updateUserRootCAS(user string, prev, next cid.Cid, blocks []cid.Cid) error {
+	if cur, _ := s.lookupRoot(user); cur != prev {
+		return echo.NewHTTPError(http.StatusConflict,
+			fmt.Sprintf("root for %s is %s, update was based on %s", user, cur, prev))
+	}
+	s.commitUserRoot(user, next, blocks)
+	return nil
+}
+
+// updateUserRoot swaps a user's root without block information, for
+// callers outside the update path.
+func (s *Server) updateUserRoot(user string, root cid.Cid) {
+	s.commitUserRoot(user, root, nil)
+}
+
+// commitUserRoot swaps the in-memory root and fans the commit out to
+// persistence, history, the root log and event subscribers.
+func (s *Server) commitUserRoot(user string, root cid.Cid, blocks []cid.Cid) {
+	s.ulk.Lock()
+	prev := s.UserRoots[user]
+	s.UserRoots[user] = root
+	s.ulk.Unlock()
+
+	changed := s.changedCollections(context.Background(), prev, root)
+
+	if s.Roots != nil {
+		if err := s.Roots.Put(user, root); err != nil {
+			s.logger().Error("failed to persist root", "user", user, "err", err)
+		}
+	}
+	s.recordHistory(user, root)
+	seq := s.RootLog.Append(user, root)
+	s.Events.Publish(&Event{Seq: seq, User: user, Root: root, Collections: changed, Blocks: blocks})
+}
+
+// collectionRoots maps collection names to their roots within a user
+// object. There is only posts today, but the event diffing below is
+// written against this so new collections join automatically.
+func collectionRoots(u *User) map[string]cid.Cid {
+	return map[string]cid.Cid{"posts": u.PostsRoot}
+}
+
+// changedCollections diffs the per-collection roots between a user's
+// old and new commits. A first commit (or an undecodable old root)
+// counts every collection as changed.
+func (s *Server) changedCollections(ctx context.Context, oldRoot, newRoot cid.Cid) []string {
+	newU, _, err := s.decodeUserRoot(ctx, s.Blockstore, newRoot)
+	if err != nil {
+		return nil
+	}
+	newRoots := collectionRoots(newU)
+
+	var oldRoots map[string]cid.Cid
+	if oldRoot.Defined() {
+		if oldU, _, err := s.decodeUserRoot(ctx, s.Blockstore, oldRoot); err == nil {
+			oldRoots = collectionRoots(oldU)
+		}
+	}
+
+	var changed []string
+	for name, root := range newRoots {
+		if oldRoots == nil || oldRoots[name] != root {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// loadCarBlocks reads every block out of a car stream into bs. A clean
+// io.EOF ends the loop, but an io.ErrUnexpectedEOF mid-block means the
+// upload was truncated and is the client's problem, not a 500.
+func loadCarBlocks(ctx context.Context, bs blockstore.Blockstore, r io.Reader, progress func(blocks int64)) (*car.CarHeader, int64, error) {
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, echo.NewHTTPError(http.StatusBadRequest, "truncated CAR")
+		}
+		return nil, 0, err
+	}
+
+	var total int64
+	var count int64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		default:
+		}
+
+		blk, err := cr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return cr.Header, total, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, 0, echo.NewHTTPError(http.StatusBadRequest, "truncated CAR")
+			}
+			return nil, 0, err
+		}
+
+		total += int64(len(blk.RawData()))
+		if err := bs.Put(ctx, blk); err != nil {
+			return nil, 0, err
+		}
+
+		count++
+		if progress != nil {
+			progress(count)
+		}
+	}
+}
+
+// defaultMaxWalkBlocks bounds update validation cost even for dags that
+// respect every other limit, a wide and deep graph could still be
+// enormous.
+const defaultMaxWalkBlocks = 1 << 17
+
+func (s *Server) maxWalkBlocks() int {
+	if s.MaxWalkBlocks > 0 {
+		return s.MaxWalkBlocks
+	}
+	return defaultMaxWalkBlocks
+}
+
+// defaultMaxBlobBytes bounds individual blobs (raw-codec blocks referenced
+// by records, eg images). The per-walk block count doesnt help here, one
+// giant leaf is a single block.
+const defaultMaxBlobBytes = 1 << 20
+
+func (s *Server) maxBlobBytes() int64 {
+	if s.MaxBlobBytes > 0 {
+		return s.MaxBlobBytes
+	}
+	return defaultMaxBlobBytes
+}
+
+// defaultMaxGraphDepth bounds dag nesting. The block cap alone still
+// admits a degenerate chain thats all depth, which costs a stack frame
+// per level to walk.
+const defaultMaxGraphDepth = 64
+
+func (s *Server) maxGraphDepth() int {
+	if s.MaxGraphDepth > 0 {
+		return s.MaxGraphDepth
+	}
+	return defaultMaxGraphDepth
+}
+
+// walkStats accumulates the shape of one update's dag during the
+// walkability check.
+type walkStats struct {
+	blocks   int
+	maxDepth int
+	maxWidth int
+}
+
+// ensureGraphWalkability checks that every block reachable from the
+// user's posts root is present in the update.
+func (s *Server) ensureGraphWalkability(ctx context.Context, u *User, bs blockstore.Blockstore) error {
+	st := &walkStats{}
+	err := s.graphWalkRec(ctx, u.PostsRoot, bs, 1, st)
+	if err == nil {
+		s.Walks.observeWalk(st)
+	}
+	return err
+}
+
+func (s *Server) graphWalkRec(ctx context.Context, c cid.Cid, bs blockstore.Blockstore, depth int, st *walkStats) error {
+	st.blocks++
+	if depth > st.maxDepth {
+		st.maxDepth = depth
+	}
+	if s.Quarantine.has(c) {
+		return echo.NewHTTPError(http.StatusUnavailableForLegalReasons,
+			fmt.Sprintf("block %s is quarantined", c))
+	}
+	if st.blocks > s.maxWalkBlocks() {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("update graph too large, walked more than %d blocks", s.maxWalkBlocks()))
+	}
+	if depth > s.maxGraphDepth() {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("update graph too deep, nested more than %d levels", s.maxGraphDepth()))
+	}
+
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		// if it wasnt in the update, it might be something we already have
+		has, err := s.Blockstore.Has(ctx, c)
+		if err != nil {
+			return err
+		}
+		if has {
+			return nil
+		}
+		return fmt.Errorf("%w: missing block %s", ErrIncompleteDAG, c)
+	}
+
+	if err := checkBlockCodec(c, blk.RawData()); err != nil {
+		return err
+	}
+
+	if c.Prefix().Codec == cid.Raw && int64(len(blk.RawData())) > s.maxBlobBytes() {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("blob %s is %d bytes, over the %d byte blob limit", c, len(blk.RawData()), s.maxBlobBytes()))
+	}
+
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(l cid.Cid) {
+		links = append(links, l)
+	}); err != nil {
+		return err
+	}
+	if len(links) > st.maxWidth {
+		st.maxWidth = len(links)
+	}
+
+	for _, l := range links {
+		if err := s.graphWalkRec(ctx, l, bs, depth+1, st); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyBlockCid recomputes a block's hash and checks it against the
+// cid the car paired with it. The car reader takes that pairing on
+// faith, so a client could otherwise declare a root whose bytes belong
+// to a different cid entirely.
+func verifyBlockCid(c cid.Cid, data []byte) error {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("block bytes do not hash to their declared cid %s", c))
+	}
+	return nil
+}
+
+// checkBlockCodec verifies that a block's bytes actually decode under the
+// codec its cid claims. A dag-cbor cid over bytes that arent cbor would
+// otherwise sail through ScanForLinks with garbage results.
+func checkBlockCodec(c cid.Cid, data []byte) error {
+	switch c.Prefix().Codec {
+	case cid.DagCBOR:
+		if _, err := cbornode.Decode(data, mh.SHA2_256, -1); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("block %s is not valid dag-cbor: %s", c, err))
+		}
+	case cid.Raw:
+		// raw blocks are opaque, nothing to check
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("block %s has unsupported codec %d", c, c.Prefix().Codec))
+	}
+	return nil
+}
+
+// verifyCommittedDag walks the freshly copied dag inside the main
+// store and fails on the first missing block. It mirrors Copy's
+// traversal (raw links are not copied, so they are not required
+// either); any hole means a write was lost between staging and here.
+func (s *Server) verifyCommittedDag(ctx context.Context, root cid.Cid, seen map[cid.Cid]bool) error {
+	if seen[root] {
+		return nil
+	}
+
+	blk, err := s.Blockstore.Get(ctx, root)
+	if err != nil {
+		return fmt.Errorf("commit verification: block %s missing after copy", root)
+	}
+	seen[root] = true
+
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(l cid.Cid) {
+		if l.Prefix().Codec != cid.Raw {
+			links = append(links, l)
+		}
+	}); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if err := s.verifyCommittedDag(ctx, l, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy moves all blocks reachable from root out of the temporary
+// blockstore into the main one, returning how many bytes landed. It
+// checks the context between blocks so a shutdown mid-copy stops
+// promptly; the caller only commits the new root on full success, so an
+// interrupted copy leaves stray blocks but never a half-updated user.
+// Every block is hash-checked on the way through: the source store was
+// filled from an untrusted car, and this is the last line before bad
+// bytes would poison the main store under a valid cid.
+func Copy(ctx context.Context, from, to blockstore.Blockstore, root cid.Cid) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	blk, err := from.Get(ctx, root)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := verifyBlockCid(root, blk.RawData()); err != nil {
+		return 0, err
+	}
+
+	if err := to.Put(ctx, blk); err != nil {
+		return 0, err
+	}
+	total := int64(len(blk.RawData()))
+
+	var links []cid.Cid
+	if err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(l cid.Cid) {
+		links = append(links, l)
+	}); err != nil {
+		return total, err
+	}
+
+	for _, l := range links {
+		if l.Prefix().Codec == cid.Raw {
+			continue
+		}
+		has, err := to.Has(ctx, l)
+		if err != nil {
+			return total, err
+		}
+		if has {
+			continue
+		}
+		n, err := Copy(ctx, from, to, l)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
@@ -0,0 +1,1564 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	blockservice "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	merkledag "github.com/ipfs/go-merkledag"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/labstack/echo/v4"
+	multibase "github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// testSigningKey is a fixed ed25519 key for signed-root tests, returned
+// with its did:key spelling.
+func testSigningKey(t *testing.T) (ed25519.PrivateKey, string) {
+	t.Helper()
+	priv := ed25519.NewKeyFromSeed([]byte("0123456789abcdef0123456789abcdef"))
+	pub := priv.Public().(ed25519.PublicKey)
+	enc, err := multibase.Encode(multibase.Base58BTC, append([]byte{0xed, 0x01}, pub...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, "did:key:" + enc
+}
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	return &Server{
+		Blockstore: blockstore.NewBlockstore(datastore.NewMapDatastore()),
+		UserRoots:  make(map[string]cid.Cid),
+		UserDids:   make(map[string]string),
+		Updates:    newUpdateQueue(),
+		RootLog:    &RootLog{},
+		Events:     NewEventBus(),
+		Resolver:   NewDidResolver(defaultDidFetch),
+		Ucans:      NewUcanStore(),
+		Keys:       NewKeyCache(resolveSigningKey),
+	}
+}
+
+// buildTestUserCar builds a minimal single-user repo and serializes it
+// to a car file, returning the root and the car bytes. It avoids
+// *testing.T so fuzz targets can use it for seed corpora.
+func buildTestUserCar(name string, nextPost int64) (cid.Cid, []byte, error) {
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+
+	postsRoot, err := HamtCollection().New(ctx, bs)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		Name:      name,
+		NextPost:  nextPost,
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		return cid.Undef, nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{user.Cid()}, buf); err != nil {
+		return cid.Undef, nil, err
+	}
+
+	return user.Cid(), buf.Bytes(), nil
+}
+
+func testUserCar(t *testing.T, name string, nextPost int64) (cid.Cid, []byte) {
+	t.Helper()
+	root, carb, err := buildTestUserCar(name, nextPost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, carb
+}
+
+// testSignedUserCar is like testUserCar but wraps the user in a
+// SignedRoot bound to the given audience.
+func testSignedUserCar(t *testing.T, name string, audience string) (cid.Cid, []byte) {
+	t.Helper()
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+
+	postsRoot, err := HamtCollection().New(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv, did := testSigningKey(t)
+
+	user, err := cbornode.WrapObject(&User{
+		DID:       did,
+		Name:      name,
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &SignedRoot{
+		User:     user.Cid(),
+		Audience: audience,
+	}
+	sr.Sig = ed25519.Sign(priv, signedRootPayload(sr))
+	sroot, err := cbornode.WrapObject(sr, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, sroot); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{sroot.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return sroot.Cid(), buf.Bytes()
+}
+
+// testSignedCarWithPosts builds a signed car whose posts hamt holds
+// nposts entries while the signed root declares declared.
+func testSignedCarWithPosts(t *testing.T, name string, audience string, nposts int, declared int64) []byte {
+	t.Helper()
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	nd := hamt.NewNode(cst)
+
+	val, err := cbornode.WrapObject(map[string]string{"text": "hi"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < nposts; i++ {
+		cc := cbg.CborCid(val.Cid())
+		if err := nd.Set(ctx, fmt.Sprintf("%d", i), &cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv, did := testSigningKey(t)
+
+	user, err := cbornode.WrapObject(&User{
+		DID:       did,
+		Name:      name,
+		NextPost:  int64(nposts),
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &SignedRoot{
+		User:      user.Cid(),
+		Audience:  audience,
+		PostCount: declared,
+	}
+	sr.Sig = ed25519.Sign(priv, signedRootPayload(sr))
+	sroot, err := cbornode.WrapObject(sr, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, sroot); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{sroot.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDeclaredPostCountChecked(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	carb := testSignedCarWithPosts(t, "alice", s.serverDid(), 1, 3)
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a count mismatch, got %v", err)
+	}
+
+	carb = testSignedCarWithPosts(t, "alice", s.serverDid(), 1, 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignedRootAudienceChecked(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	_, carb := testSignedUserCar(t, "alice", "did:key:zSomeOtherServer")
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a root signed for another server, got %v", err)
+	}
+
+	root, carb := testSignedUserCar(t, "alice", s.serverDid())
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	if s.UserRoots["alice"] != root {
+		t.Fatal("signed root for this server should commit")
+	}
+}
+
+func TestGetSignedRoot(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	root, carb := testSignedUserCar(t, "alice", s.serverDid())
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+
+	if err := s.handleGetSignedRoot(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the returned block should be exactly the stored signed root
+	blk, err := s.Blockstore.Get(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), blk.RawData()) {
+		t.Fatal("signed root bytes do not round-trip")
+	}
+
+	var sr SignedRoot
+	if err := cbornode.DecodeInto(rec.Body.Bytes(), &sr); err != nil {
+		t.Fatal(err)
+	}
+	if !sr.User.Defined() || len(sr.Sig) == 0 {
+		t.Fatal("returned block is not a signed root")
+	}
+
+	// a user with a legacy unsigned root gets a 404
+	_, legacy := testUserCar(t, "bob", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("bob")
+	err = s.handleGetSignedRoot(c)
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 404 {
+		t.Fatalf("expected 404 for unsigned root, got %v", err)
+	}
+}
+
+func TestHandleSuffixEnforcement(t *testing.T) {
+	s := testServer(t)
+	s.AllowedHandleSuffixes = []string{".example.com", ".example.org"}
+
+	if err := s.putNewUser("alice.example.com", "did:key:zAlice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putNewUser("bob.example.org", "did:key:zBob"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.putNewUser("mallory.elsewhere.net", "did:key:zMallory")
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a disallowed handle domain, got %v", err)
+	}
+}
+
+func TestMaxHandlesPerDid(t *testing.T) {
+	s := testServer(t)
+	s.MaxHandlesPerDid = 2
+
+	if err := s.putNewUser("alice", "did:key:zSame"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putNewUser("alice2", "did:key:zSame"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.putNewUser("alice3", "did:key:zSame")
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 403 {
+		t.Fatalf("expected 403 past the handle cap, got %v", err)
+	}
+
+	// a different did is unaffected
+	if err := s.putNewUser("bob", "did:key:zOther"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileUserMaps(t *testing.T) {
+	s := testServer(t)
+
+	if err := s.putNewUser("alice", "did:key:zAlice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a did recorded without a matching root
+	s.UserDids["ghost"] = "did:key:zGhost"
+
+	cleaned := s.reconcileUserMaps()
+	if len(cleaned) != 1 || cleaned[0] != "ghost" {
+		t.Fatalf("expected ghost to be cleaned up, got %v", cleaned)
+	}
+	if _, ok := s.UserDids["ghost"]; ok {
+		t.Fatal("half-entry survived reconciliation")
+	}
+	if _, ok := s.UserRoots["alice"]; !ok {
+		t.Fatal("consistent entry should survive reconciliation")
+	}
+}
+
+func TestCodecMismatchRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// a dag-cbor tagged cid over bytes that are not cbor at all
+	data := []byte("definitely not cbor")
+	pref := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}
+	c, err := pref.Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpbs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	if err := tmpbs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.graphWalkRec(ctx, c, tmpbs, 1, &walkStats{}); err == nil {
+		t.Fatal("expected codec mismatch to be rejected")
+	}
+}
+
+func TestProgressFramesDuringImport(t *testing.T) {
+	ctx := context.TODO()
+
+	_, carb := testUserCar(t, "alice", 0)
+
+	tmpbs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	var frames []int64
+	_, _, err := loadCarBlocks(ctx, tmpbs, bytes.NewReader(carb), func(n int64) {
+		frames = append(frames, n)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the test car has two blocks, so we expect two progress frames
+	if len(frames) != 2 || frames[0] != 1 || frames[1] != 2 {
+		t.Fatalf("unexpected progress frames: %v", frames)
+	}
+}
+
+func TestDecodeErrHexContext(t *testing.T) {
+	s := testServer(t)
+
+	data := []byte{0xa1, 0x61, 'x', 0xff}
+	base := errors.New("unexpected cbor type")
+
+	err := s.decodeErr("user", data, base)
+	if strings.Contains(err.Error(), "a16178ff") {
+		t.Fatalf("hex context should not leak outside debug mode: %s", err)
+	}
+
+	s.Debug = true
+	err = s.decodeErr("user", data, base)
+	if !strings.Contains(err.Error(), "a16178ff") {
+		t.Fatalf("expected hex context in debug mode: %s", err)
+	}
+	if !strings.Contains(err.Error(), "user") {
+		t.Fatalf("expected decode path in error: %s", err)
+	}
+}
+
+func TestWalkBlockCap(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.MaxWalkBlocks = 2
+
+	// a three-deep chain of linked nodes
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	leaf, err := cbornode.WrapObject(map[string]string{"v": "leaf"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mid, err := cbornode.WrapObject(map[string]interface{}{"next": leaf.Cid()}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := cbornode.WrapObject(map[string]interface{}{"next": mid.Cid()}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []blocks.Block{leaf, mid, top} {
+		if err := bs.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.graphWalkRec(ctx, top.Cid(), bs, 1, &walkStats{})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 past the walk cap, got %v", err)
+	}
+
+	s.MaxWalkBlocks = 10
+	if err := s.graphWalkRec(ctx, top.Cid(), bs, 1, &walkStats{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkDepthCap(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.MaxGraphDepth = 2
+
+	// the same three-deep chain, all depth and no width
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	leaf, err := cbornode.WrapObject(map[string]string{"v": "leaf"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mid, err := cbornode.WrapObject(map[string]interface{}{"next": leaf.Cid()}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := cbornode.WrapObject(map[string]interface{}{"next": mid.Cid()}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []blocks.Block{leaf, mid, top} {
+		if err := bs.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.graphWalkRec(ctx, top.Cid(), bs, 1, &walkStats{})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 past the depth cap, got %v", err)
+	}
+	if !strings.Contains(fmt.Sprint(err.(*echo.HTTPError).Message), "deep") {
+		t.Fatalf("error should mention depth: %v", err)
+	}
+
+	// exactly at the cap is fine
+	s.MaxGraphDepth = 3
+	if err := s.graphWalkRec(ctx, top.Cid(), bs, 1, &walkStats{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTruncatedCarRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	_, carb := testUserCar(t, "alice", 0)
+
+	// chop the car off mid-block
+	err := s.runUpdate(ctx, bytes.NewReader(carb[:len(carb)-10]))
+	if err == nil {
+		t.Fatal("expected truncated car to be rejected")
+	}
+
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != 400 {
+		t.Fatalf("expected a 400 for a truncated car, got %v", err)
+	}
+}
+
+func TestAsyncUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := testServer(t)
+	go s.runUpdateWorker(ctx)
+
+	root, carb := testUserCar(t, "alice", 0)
+
+	job, err := s.enqueueUpdate(carb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != UpdateStatusPending {
+		t.Fatalf("expected fresh job to be pending, got %s", job.Status)
+	}
+
+	var status string
+	for i := 0; i < 100; i++ {
+		s.Updates.lk.Lock()
+		status = s.Updates.jobs[job.ID].Status
+		s.Updates.lk.Unlock()
+		if status != UpdateStatusPending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status != UpdateStatusCommitted {
+		t.Fatalf("expected job to commit, got %s", status)
+	}
+	if s.UserRoots["alice"] != root {
+		t.Fatal("async update did not set the user root")
+	}
+}
+
+func TestAsyncUpdateQueueFull(t *testing.T) {
+	// no worker draining, so the channel buffer is the whole capacity
+	s := testServer(t)
+
+	for i := 0; i < cap(s.Updates.work); i++ {
+		if _, err := s.enqueueUpdate(nil); err != nil {
+			t.Fatalf("enqueue %d should fit in the buffer: %v", i, err)
+		}
+	}
+
+	// the next one must be refused, not block the handler forever
+	_, err := s.enqueueUpdate(nil)
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != 503 {
+		t.Fatalf("expected 503 when the queue is full, got %v", err)
+	}
+
+	// the refused job must not linger as forever-pending
+	s.Updates.lk.Lock()
+	n := len(s.Updates.jobs)
+	s.Updates.lk.Unlock()
+	if n != cap(s.Updates.work) {
+		t.Fatalf("expected %d tracked jobs, got %d", cap(s.Updates.work), n)
+	}
+}
+
+func TestUpdateStatusUnknownJob(t *testing.T) {
+	s := testServer(t)
+
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues("deadbeef")
+
+	err := s.handleUpdateStatus(c)
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != 404 {
+		t.Fatalf("expected 404 for an unknown job, got %v", err)
+	}
+}
+
+func TestFinishedJobsEvicted(t *testing.T) {
+	q := newUpdateQueue()
+
+	q.lk.Lock()
+	for i := 0; i < maxFinishedJobs+10; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		q.jobs[id] = &updateJob{ID: id, Status: UpdateStatusCommitted}
+		q.finish(id)
+	}
+	q.lk.Unlock()
+
+	if len(q.jobs) != maxFinishedJobs {
+		t.Fatalf("expected %d retained jobs, got %d", maxFinishedJobs, len(q.jobs))
+	}
+	if _, ok := q.jobs["job-0"]; ok {
+		t.Fatal("the oldest finished job should have been evicted")
+	}
+	if _, ok := q.jobs[fmt.Sprintf("job-%d", maxFinishedJobs+9)]; !ok {
+		t.Fatal("the newest finished job should be retained")
+	}
+}
+
+func TestDivergentDidRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	if err := s.putNewUser("alice", "did:key:z6MkAlice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a car whose user block claims a different did than the registration
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	postsRoot, err := HamtCollection().New(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cbornode.WrapObject(&User{
+		DID:       "did:key:z6MkMallory",
+		Name:      "alice",
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{user.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.runUpdate(ctx, bytes.NewReader(buf.Bytes()))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 403 {
+		t.Fatalf("expected a 403 for the did mismatch, got %v", err)
+	}
+	if s.UserRoots["alice"].Defined() {
+		t.Fatal("no root should be committed after a did mismatch")
+	}
+}
+
+func TestSnapshotReadSurvivesGC(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	_, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	root := s.UserRoots["alice"]
+
+	// capture the snapshot a reader would stream from
+	snap, err := s.snapshotDag(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gc deletes the whole dag out from under the reader
+	s.deleteReachable(ctx, root)
+	if has, _ := s.Blockstore.Has(ctx, root); has {
+		t.Fatal("gc should have removed the root from the live store")
+	}
+
+	// the in-flight download still completes from the snapshot
+	buf := new(bytes.Buffer)
+	if err := writeCarFrom(ctx, snap, root, buf); err != nil {
+		t.Fatalf("snapshot download should survive gc: %s", err)
+	}
+
+	cr, err := car.NewCarReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks int
+	for {
+		if _, err := cr.Next(); err != nil {
+			break
+		}
+		blocks++
+	}
+	if blocks != 2 {
+		t.Fatalf("expected the full two-block car, got %d blocks", blocks)
+	}
+}
+
+func TestOversizedBlobRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.MaxBlobBytes = 64
+
+	// a record linking to a raw blob bigger than the limit
+	blob := make([]byte, 200)
+	pref := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}
+	bc, err := pref.Sum(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(blob, bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := cbornode.WrapObject(map[string]interface{}{"image": bc}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	for _, nd := range []blocks.Block{blk, rec} {
+		if err := bs.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.graphWalkRec(ctx, rec.Cid(), bs, 1, &walkStats{})
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 413 {
+		t.Fatalf("expected 413 for an oversized blob, got %v", err)
+	}
+	if !strings.Contains(fmt.Sprint(he.Message), bc.String()) {
+		t.Fatalf("error should name the blob cid: %v", he.Message)
+	}
+
+	// raising the limit makes the same graph acceptable
+	s.MaxBlobBytes = 1024
+	if err := s.graphWalkRec(ctx, rec.Cid(), bs, 1, &walkStats{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPartialUserCar(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	present, err := cbornode.WrapObject(map[string]string{"v": "here"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	absent, err := cbornode.WrapObject(map[string]string{"v": "gone"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := cbornode.WrapObject(map[string]interface{}{
+		"a": present.Cid(), "b": absent.Cid(),
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// deliberately skip putting the absent block
+	for _, nd := range []blocks.Block{present, root} {
+		if err := s.Blockstore.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.UserRoots["alice"] = root.Cid()
+	s.UserDids["alice"] = "did:key:zAlice"
+
+	e := echo.New()
+
+	// the strict path refuses to serve a holey dag
+	c := e.NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s.handleGetUser(c); err == nil {
+		t.Fatal("expected the full download to fail on the missing block")
+	}
+
+	// the partial path serves what it has and reports the hole
+	rec := httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest("GET", "/?partial=true", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("alice")
+	if err := s.handleGetUser(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rec.Header().Get(MissingBlocksHeader); got != absent.Cid().String() {
+		t.Fatalf("missing header = %q, want %q", got, absent.Cid())
+	}
+
+	cr, err := car.NewCarReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[cid.Cid]bool)
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			break
+		}
+		got[blk.Cid()] = true
+	}
+	if !got[root.Cid()] || !got[present.Cid()] || got[absent.Cid()] {
+		t.Fatalf("unexpected partial car contents: %v", got)
+	}
+}
+
+func TestUpdateSizeLimit(t *testing.T) {
+	ctx := context.TODO()
+
+	_, carb := testUserCar(t, "alice", 0)
+
+	// just under the limit goes through
+	s := testServer(t)
+	s.MaxUpdateBytes = int64(len(carb))
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	// one byte too small and the same car is refused
+	s = testServer(t)
+	s.MaxUpdateBytes = int64(len(carb)) - 1
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 413 {
+		t.Fatalf("expected 413 for an oversized update, got %v", err)
+	}
+}
+
+func TestUpdateCAS(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	root1, car1 := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(car1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// two clients both build on root1 and race their commits
+	_, carA := testUserCar(t, "alice", 1)
+	_, carB := testUserCar(t, "alice", 2)
+
+	errs := make(chan error, 2)
+	for _, carb := range [][]byte{carA, carB} {
+		carb := carb
+		go func() {
+			errs <- s.runUpdateDeclared(ctx, bytes.NewReader(carb), nil, &root1, nil)
+		}()
+	}
+
+	var ok, conflict int
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err == nil {
+			ok++
+			continue
+		}
+		if he, isHTTP := err.(*echo.HTTPError); isHTTP && he.Code == 409 {
+			conflict++
+			continue
+		}
+		t.Fatal(err)
+	}
+	if ok != 1 || conflict != 1 {
+		t.Fatalf("expected exactly one winner, got %d ok / %d conflict", ok, conflict)
+	}
+
+	// a prev that was never the root is refused outright
+	_, carC := testUserCar(t, "alice", 3)
+	err := s.runUpdateDeclared(ctx, bytes.NewReader(carC), nil, &root1, nil)
+	if he, isHTTP := err.(*echo.HTTPError); !isHTTP || he.Code != 409 {
+		t.Fatalf("expected 409 for a stale prev, got %v", err)
+	}
+}
+
+func TestUpdateAuthChecksActualUser(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// the auth callback the handler builds from a token scoped to one
+	// account
+	authFor := func(holder string) userAuthFn {
+		return func(name string) error {
+			if name != holder {
+				return fmt.Errorf("%w: token does not grant the required capability", ErrUnauthorized)
+			}
+			return nil
+		}
+	}
+
+	// alice's token cannot push an update to bob's repo
+	_, bobCar := testUserCar(t, "bob", 0)
+	err := s.runUpdateDeclared(ctx, bytes.NewReader(bobCar), nil, nil, authFor("alice"))
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected unauthorized for a cross-user update, got %v", err)
+	}
+	if _, ok := s.UserRoots["bob"]; ok {
+		t.Fatal("rejected update must not commit")
+	}
+
+	// the same token works for alice's own repo
+	_, aliceCar := testUserCar(t, "alice", 0)
+	if err := s.runUpdateDeclared(ctx, bytes.NewReader(aliceCar), nil, nil, authFor("alice")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRootContentMismatchRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	postsRoot, err := cst.Put(ctx, hamt.NewNode(cst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := cbornode.WrapObject(&User{Name: "alice", PostsRoot: postsRoot}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := cbornode.WrapObject(&User{Name: "bob", PostsRoot: postsRoot}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postsBlk, err := bs.Get(ctx, postsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// declare bob's cid as the root, but ship alice's bytes under it
+	hb, err := cbornode.DumpObject(map[string]interface{}{"version": 1, "roots": []cid.Cid{bob.Cid()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	for _, w := range [][][]byte{
+		{hb},
+		{bob.Cid().Bytes(), alice.RawData()},
+		{postsRoot.Bytes(), postsBlk.RawData()},
+	} {
+		if err := carutil.LdWrite(buf, w...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.runUpdate(ctx, bytes.NewReader(buf.Bytes()))
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a root whose bytes hash elsewhere, got %v", err)
+	}
+	if !strings.Contains(fmt.Sprint(he.Message), "hash") {
+		t.Fatalf("error should say the bytes dont hash to the cid: %v", he.Message)
+	}
+	if len(s.UserRoots) != 0 {
+		t.Fatal("rejected update must not commit anything")
+	}
+}
+
+func TestNonUserRootRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// valid dag-cbor, but not a user object. the lenient decoder still
+	// produces a User from it, the round-trip check is what catches it
+	nd, err := cbornode.WrapObject(map[string]string{"Name": "mallory"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := cbornode.DumpObject(map[string]interface{}{"version": 1, "roots": []cid.Cid{nd.Cid()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := carutil.LdWrite(buf, hb); err != nil {
+		t.Fatal(err)
+	}
+	if err := carutil.LdWrite(buf, nd.Cid().Bytes(), nd.RawData()); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.runUpdate(ctx, bytes.NewReader(buf.Bytes()))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for a non-user root, got %v", err)
+	}
+}
+
+// cancelOnPutBs cancels its context the moment the first block lands,
+// simulating a shutdown arriving mid-copy.
+type cancelOnPutBs struct {
+	blockstore.Blockstore
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnPutBs) Put(ctx context.Context, blk blocks.Block) error {
+	b.cancel()
+	return b.Blockstore.Put(ctx, blk)
+}
+
+func TestCancelMidCopyCommitsNothing(t *testing.T) {
+	s := testServer(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	s.Blockstore = &cancelOnPutBs{Blockstore: s.Blockstore, cancel: cancel}
+
+	_, carb := testUserCar(t, "alice", 0)
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, ok := s.UserRoots["alice"]; ok {
+		t.Fatal("a canceled copy must not commit a root")
+	}
+}
+
+func TestDanglingLinkRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// a posts hamt whose single entry points at a block nobody ever sent
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	nd := hamt.NewNode(cst)
+
+	pref := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}
+	missing, err := pref.Sum([]byte("a block nobody sent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := cbg.CborCid(missing)
+	if err := nd.Set(ctx, "0", &cc); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cbornode.WrapObject(&User{Name: "alice", NextPost: 1, PostsRoot: postsRoot}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hamtBlk, err := bs.Get(ctx, postsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hb, err := cbornode.DumpObject(map[string]interface{}{"version": 1, "roots": []cid.Cid{user.Cid()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	for _, w := range [][][]byte{
+		{hb},
+		{user.Cid().Bytes(), user.RawData()},
+		{postsRoot.Bytes(), hamtBlk.RawData()},
+	} {
+		if err := carutil.LdWrite(buf, w...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.runUpdate(ctx, bytes.NewReader(buf.Bytes()))
+	if !errors.Is(err, ErrIncompleteDAG) {
+		t.Fatalf("expected ErrIncompleteDAG, got %v", err)
+	}
+	if !strings.Contains(err.Error(), missing.String()) {
+		t.Fatalf("error should name the missing cid: %v", err)
+	}
+	if len(s.UserRoots) != 0 {
+		t.Fatal("an incomplete dag must not commit")
+	}
+}
+
+func TestConditionalGetUser(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	root, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	get := func(etag string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest("GET", "/", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("alice")
+		return rec, s.handleGetUser(c)
+	}
+
+	// an unconditional fetch carries the root as its etag
+	rec, err := get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"` + root.String() + `"`
+	if got := rec.Header().Get("ETag"); got != want {
+		t.Fatalf("etag %q, want %q", got, want)
+	}
+	if rec.Body.Len() == 0 {
+		t.Fatal("expected a car body")
+	}
+
+	// a client already holding the current root gets a 304 and no body
+	rec, err = get(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 304 || rec.Body.Len() != 0 {
+		t.Fatalf("expected an empty 304, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	// a stale etag still gets the full download
+	rec, err = get(`"bafystale"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 200 || rec.Body.Len() == 0 {
+		t.Fatalf("expected a fresh 200, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+// buildUserCarWithPosts is the unsigned cousin of testSignedCarWithPosts,
+// for tests that care about the dag rather than the signature.
+func buildUserCarWithPosts(t *testing.T, name string, nposts int) (cid.Cid, []byte) {
+	t.Helper()
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	nd := hamt.NewNode(cst)
+
+	val, err := cbornode.WrapObject(map[string]string{"text": "hi"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, val); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < nposts; i++ {
+		cc := cbg.CborCid(val.Cid())
+		if err := nd.Set(ctx, fmt.Sprintf("%d", i), &cc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nd.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		Name:      name,
+		NextPost:  int64(nposts),
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{user.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+	return user.Cid(), buf.Bytes()
+}
+
+func TestSinceDiffFetch(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	root1, car1 := buildUserCarWithPosts(t, "alice", 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(car1)); err != nil {
+		t.Fatal(err)
+	}
+	_, car2 := buildUserCarWithPosts(t, "alice", 2)
+	if err := s.runUpdate(ctx, bytes.NewReader(car2)); err != nil {
+		t.Fatal(err)
+	}
+
+	carCids := func(data []byte) map[cid.Cid]bool {
+		cr, err := car.NewCarReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		set := make(map[cid.Cid]bool)
+		for {
+			blk, err := cr.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return set
+				}
+				t.Fatal(err)
+			}
+			set[blk.Cid()] = true
+		}
+	}
+
+	e := echo.New()
+	fetch := func(query string) []byte {
+		req := httptest.NewRequest("GET", "/"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("alice")
+		if err := s.handleGetUser(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Body.Bytes()
+	}
+
+	full := carCids(fetch(""))
+	diff := carCids(fetch("?since=" + root1.String()))
+	old := carCids(car1)
+
+	if len(diff) == 0 || len(diff) >= len(full) {
+		t.Fatalf("diff should be a proper subset: %d of %d blocks", len(diff), len(full))
+	}
+	for c := range diff {
+		if !full[c] {
+			t.Fatalf("diff block %s not in the full fetch", c)
+		}
+		if old[c] {
+			t.Fatalf("diff block %s was already reachable from since", c)
+		}
+	}
+	// everything in the current repo is covered by what the client had
+	// plus the diff
+	for c := range full {
+		if !diff[c] && !old[c] {
+			t.Fatalf("block %s in neither the old set nor the diff", c)
+		}
+	}
+}
+
+func TestConcurrentRegistrationOneWinner(t *testing.T) {
+	s := testServer(t)
+
+	const n = 16
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errs <- s.putNewUser("alice", "") }()
+	}
+
+	var won, conflicted int
+	for i := 0; i < n; i++ {
+		err := <-errs
+		switch {
+		case err == nil:
+			won++
+		case errors.Is(err, ErrConflict):
+			conflicted++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if won != 1 || conflicted != n-1 {
+		t.Fatalf("expected exactly one winner, got %d winners / %d conflicts", won, conflicted)
+	}
+}
+
+func TestLookupByDidAndName(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	_, did := testSigningKey(t)
+	if err := s.putNewUser("alice", did); err != nil {
+		t.Fatal(err)
+	}
+
+	_, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	byName, err := s.getUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDid, err := s.getUser(did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !byName.Defined() || byName != byDid {
+		t.Fatalf("name and did lookups disagree: %s vs %s", byName, byDid)
+	}
+
+	// an unregistered did still misses
+	if _, err := s.getUser("did:key:zNobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound for an unknown did, got %v", err)
+	}
+}
+
+func TestResolverAcceptsEitherKey(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	_, did := testSigningKey(t)
+	if err := s.putNewUser("alice", did); err != nil {
+		t.Fatal(err)
+	}
+	_, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.resolveUser(did); got != "alice" {
+		t.Fatalf("did should resolve to the handle, got %q", got)
+	}
+	if got := s.resolveUser("alice"); got != "alice" {
+		t.Fatalf("a handle resolves to itself, got %q", got)
+	}
+
+	// history, like the repo fetch, answers under either key
+	e := echo.New()
+	for _, id := range []string{"alice", did} {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest("GET", "/", nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		if err := s.handleUserHistory(c); err != nil {
+			t.Fatalf("history by %q: %v", id, err)
+		}
+		if rec.Code != 200 {
+			t.Fatalf("history by %q: status %d", id, rec.Code)
+		}
+	}
+
+	// deletion resolves the did to the same account
+	if _, _, err := s.deleteUser(ctx, did, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.lookupRoot("alice"); ok {
+		t.Fatal("deleting by did should remove the handle entry")
+	}
+}
+
+func TestServerInitDisabledByDefault(t *testing.T) {
+	s := testServer(t)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/register/alt", strings.NewReader(`{"name":"alice"}`))
+	err := s.handleRegisterUserAlt(e.NewContext(req, rec))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 501 {
+		t.Fatalf("expected 501 while server-init is off, got %v", err)
+	}
+}
+
+func TestServerInitBootstrapsEmptyRepo(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.ServerInit = true
+
+	if err := s.putNewUser("alice", "did:key:zAlice"); err != nil {
+		t.Fatal(err)
+	}
+	root, err := s.initUserRepo(ctx, "alice", "did:key:zAlice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.updateUserRoot("alice", root)
+
+	// the committed root decodes to a well-formed user carrying the
+	// registered did
+	u, _, err := s.decodeUserRoot(ctx, s.Blockstore, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "alice" || u.DID != "did:key:zAlice" {
+		t.Fatalf("unexpected bootstrapped user: %+v", u)
+	}
+
+	// the posts hamt exists and is empty
+	nd, err := hamt.LoadNode(ctx, cbornode.NewCborStore(s.Blockstore), u.PostsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var posts int
+	if err := nd.ForEach(ctx, func(k string, val *cbg.Deferred) error {
+		posts++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 0 {
+		t.Fatalf("bootstrapped posts hamt should be empty, holds %d", posts)
+	}
+
+	// the car handed back to the client is complete: exactly the user
+	// object and the empty hamt, rooted at the new commit
+	var buf bytes.Buffer
+	if err := writeCarFrom(ctx, s.Blockstore, root, &buf); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := car.NewCarReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Header.Roots) != 1 || cr.Header.Roots[0] != root {
+		t.Fatalf("car root should be the new commit, got %v", cr.Header.Roots)
+	}
+	var blocks int
+	for {
+		if _, err := cr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		blocks++
+	}
+	if blocks != 2 {
+		t.Fatalf("expected user object plus empty hamt, got %d blocks", blocks)
+	}
+}
+
+func TestCopyRejectsCorruptBlock(t *testing.T) {
+	ctx := context.TODO()
+
+	good, err := cbornode.WrapObject(map[string]string{"text": "hi"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a source store holding different bytes under the good cid, as a
+	// buggy or hostile staging path might
+	from := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	evil, err := blocks.NewBlockWithCid([]byte("not those bytes"), good.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Put(ctx, evil); err != nil {
+		t.Fatal(err)
+	}
+
+	to := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	if _, err := Copy(ctx, from, to, good.Cid()); err == nil {
+		t.Fatal("expected Copy to reject a block whose bytes dont match its cid")
+	}
+
+	has, err := to.Has(ctx, good.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("corrupt block must not reach the destination store")
+	}
+}
+
+// lossyBs pretends to store a chosen block while writing nothing, the
+// shape of a lost write between staging and commit.
+type lossyBs struct {
+	blockstore.Blockstore
+	skip cid.Cid
+}
+
+func (l *lossyBs) Put(ctx context.Context, blk blocks.Block) error {
+	if blk.Cid() == l.skip {
+		return nil
+	}
+	return l.Blockstore.Put(ctx, blk)
+}
+
+func TestLostWriteKeepsOldRoot(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	root1, car1 := buildUserCarWithPosts(t, "alice", 1)
+	if err := s.runUpdate(ctx, bytes.NewReader(car1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second update's posts root silently fails to persist
+	root2, car2 := buildUserCarWithPosts(t, "alice", 2)
+	cr, err := car.NewCarReader(bytes.NewReader(car2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u User
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blk.Cid() == root2 {
+			if err := cbornode.DecodeInto(blk.RawData(), &u); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	s.Blockstore = &lossyBs{Blockstore: s.Blockstore, skip: u.PostsRoot}
+
+	if err := s.runUpdate(ctx, bytes.NewReader(car2)); err == nil {
+		t.Fatal("expected the commit recheck to catch the lost write")
+	}
+
+	// the old root is still the one served
+	got, err := s.getUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != root1 {
+		t.Fatalf("root moved despite a failed commit: %s", got)
+	}
+}
+
+func TestUpdateSizeLimitCoversAllVariants(t *testing.T) {
+	_, carb := testUserCar(t, "alice", 0)
+
+	// every buffered variant of /update has to honor the same budget as
+	// the streaming path
+	for _, query := range []string{"?dry-run=true", "?async=true", "?progress=true"} {
+		s := testServer(t)
+		s.MaxUpdateBytes = int64(len(carb)) - 1
+
+		e := echo.New()
+		req := httptest.NewRequest("POST", "/update"+query, bytes.NewReader(carb))
+		rec := httptest.NewRecorder()
+
+		err := s.handleUserUpdate(e.NewContext(req, rec))
+		if query == "?progress=true" {
+			// progress mode reports errors as ndjson frames on a 200
+			if err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+			if !strings.Contains(rec.Body.String(), "byte limit") {
+				t.Fatalf("%s: expected a size limit error frame, got %q", query, rec.Body.String())
+			}
+			continue
+		}
+		if he, ok := err.(*echo.HTTPError); !ok || he.Code != 413 {
+			t.Fatalf("%s: expected 413 for an oversized update, got %v", query, err)
+		}
+	}
+}
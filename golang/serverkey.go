@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	multibase "github.com/multiformats/go-multibase"
+)
+
+// ServerKey is the server's own signing identity: an ed25519 keypair
+// with the did:key spelling of its public half. A server without one
+// falls back to the legacy shared TwitterDid identity and cannot sign
+// anything itself.
+type ServerKey struct {
+	Priv ed25519.PrivateKey
+	DID  string
+}
+
+// didKeyFromPub spells an ed25519 public key as a did:key did, the
+// inverse of parseDidKey.
+func didKeyFromPub(pub ed25519.PublicKey) (string, error) {
+	enc, err := multibase.Encode(multibase.Base58BTC,
+		append(append([]byte{}, didKeyEd25519Prefix...), pub...))
+	if err != nil {
+		return "", err
+	}
+	return "did:key:" + enc, nil
+}
+
+// NewServerKey derives the full keypair and did from a 32-byte ed25519
+// seed.
+func NewServerKey(seed []byte) (*ServerKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("server key seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+
+	priv := ed25519.NewKeyFromSeed(seed)
+	did, err := didKeyFromPub(priv.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	return &ServerKey{Priv: priv, DID: did}, nil
+}
+
+// Sign signs data with the server's private key.
+func (k *ServerKey) Sign(data []byte) []byte {
+	return ed25519.Sign(k.Priv, data)
+}
+
+// loadServerKey resolves the server keypair at startup: an explicit key
+// file wins, then the ATPROTO_SERVER_KEY environment, both holding a
+// hex-encoded 32-byte seed. Neither being set means no key.
+func loadServerKey(path, env string) (*ServerKey, error) {
+	var hexSeed string
+	switch {
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading server key: %w", err)
+		}
+		hexSeed = strings.TrimSpace(string(data))
+	case env != "":
+		hexSeed = strings.TrimSpace(env)
+	default:
+		return nil, nil
+	}
+
+	seed, err := hex.DecodeString(hexSeed)
+	if err != nil {
+		return nil, fmt.Errorf("server key is not hex: %w", err)
+	}
+	return NewServerKey(seed)
+}
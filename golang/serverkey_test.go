@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestServerKeyDerivedDid(t *testing.T) {
+	key, err := NewServerKey([]byte("fedcba9876543210fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServer(Config{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.serverDid() != key.DID {
+		t.Fatalf("server did %q does not match the key's did %q", s.serverDid(), key.DID)
+	}
+	if s.serverDid() == TwitterDid {
+		t.Fatal("a configured key must replace the shared legacy identity")
+	}
+
+	// the published did document reports the derived did
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/.well-known/did.json", nil)
+	req.Host = "pds.example.com"
+	if err := s.handleGetDid(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	var doc didDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Id != key.DID {
+		t.Fatalf("did document id = %q, want %q", doc.Id, key.DID)
+	}
+
+	// signatures made with the key verify against the did it derives
+	pub, err := parseDidKey(key.DID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello")
+	if !ed25519.Verify(pub, msg, key.Sign(msg)) {
+		t.Fatal("signature does not verify against the derived did")
+	}
+}
+
+func TestLoadServerKey(t *testing.T) {
+	seed := []byte("0123456789abcdef0123456789abcdef")
+	hexSeed := hex.EncodeToString(seed)
+	want, err := NewServerKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "server.key")
+	if err := os.WriteFile(path, []byte(hexSeed+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := loadServerKey(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.DID != want.DID {
+		t.Fatalf("file-loaded did %q, want %q", key.DID, want.DID)
+	}
+
+	// the environment works too, but the file wins when both are set
+	key, err = loadServerKey("", hexSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.DID != want.DID {
+		t.Fatalf("env-loaded did %q, want %q", key.DID, want.DID)
+	}
+
+	// neither source set means no key, not an error
+	key, err = loadServerKey("", "")
+	if err != nil || key != nil {
+		t.Fatalf("expected no key and no error, got %v, %v", key, err)
+	}
+
+	if _, err := loadServerKey("", "not hex"); err == nil {
+		t.Fatal("non-hex seed should be rejected")
+	}
+	if _, err := loadServerKey("", hex.EncodeToString([]byte("short"))); err == nil {
+		t.Fatal("wrong-length seed should be rejected")
+	}
+	if _, err := loadServerKey(filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+		t.Fatal("a missing key file should be an error, not a silent fallback")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	multibase "github.com/multiformats/go-multibase"
+)
+
+// signedRootPayload is the exact byte string a client signs when
+// producing a SignedRoot. Binding every field keeps a valid signature
+// from being replayed with a swapped audience or nonce.
+func signedRootPayload(sr *SignedRoot) []byte {
+	return []byte(fmt.Sprintf("atp-signed-root\n%s\n%s\n%s\n%d",
+		sr.User, sr.Audience, sr.Nonce, sr.PostCount))
+}
+
+// ed25519 multicodec prefix used in did:key encodings
+var didKeyEd25519Prefix = []byte{0xed, 0x01}
+
+// parseDidKey extracts the ed25519 public key embedded in a did:key did.
+func parseDidKey(did string) (ed25519.PublicKey, error) {
+	if !strings.HasPrefix(did, "did:key:") {
+		return nil, fmt.Errorf("not a did:key did: %s", did)
+	}
+
+	_, data, err := multibase.Decode(strings.TrimPrefix(did, "did:key:"))
+	if err != nil {
+		return nil, fmt.Errorf("bad did:key encoding: %w", err)
+	}
+	if len(data) != len(didKeyEd25519Prefix)+ed25519.PublicKeySize ||
+		data[0] != didKeyEd25519Prefix[0] || data[1] != didKeyEd25519Prefix[1] {
+		return nil, fmt.Errorf("did:key %s does not hold an ed25519 key", did)
+	}
+	return ed25519.PublicKey(data[len(didKeyEd25519Prefix):]), nil
+}
+
+// verifySignedRoot checks the signature on an uploaded signed root
+// against the key in the issuer's did. The did comes from the user block
+// itself, falling back to the did registered for the handle. Key lookups
+// go through the signing-key cache so a busy user's key is resolved
+// once, not on every update.
+func (s *Server) verifySignedRoot(ctx context.Context, u *User, sr *SignedRoot) error {
+	did := u.DID
+	if did == "" {
+		did, _ = s.lookupDid(u.Name)
+	}
+	if did == "" {
+		return echo.NewHTTPError(http.StatusUnauthorized,
+			"signed root has no did to verify against")
+	}
+
+	pub, err := s.signingKey(ctx, did)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	if !ed25519.Verify(pub, signedRootPayload(sr), sr.Sig) {
+		return echo.NewHTTPError(http.StatusUnauthorized,
+			"signed root signature verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	blockservice "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	merkledag "github.com/ipfs/go-merkledag"
+	car "github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// buildSignedRootCar builds a signed car for "alice" bound to this
+// server, with the signature produced by sign so tests can make it
+// wrong in controlled ways.
+func buildSignedRootCar(t *testing.T, did string, sign func(*SignedRoot) []byte) (cid.Cid, []byte) {
+	t.Helper()
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+
+	postsRoot, err := HamtCollection().New(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		DID:       did,
+		Name:      "alice",
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := &SignedRoot{User: user.Cid(), Audience: TwitterDid}
+	sr.Sig = sign(sr)
+	sroot, err := cbornode.WrapObject(sr, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, sroot); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{sroot.Cid()}, buf); err != nil {
+		t.Fatal(err)
+	}
+	return sroot.Cid(), buf.Bytes()
+}
+
+func TestSignedRootVerified(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	priv, did := testSigningKey(t)
+
+	root, carb := buildSignedRootCar(t, did, func(sr *SignedRoot) []byte {
+		return ed25519.Sign(priv, signedRootPayload(sr))
+	})
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+	if s.UserRoots["alice"] != root {
+		t.Fatal("correctly signed root should commit")
+	}
+}
+
+func TestSignedRootTamperRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	priv, did := testSigningKey(t)
+
+	// signature over a payload the root doesnt actually carry
+	root, carb := buildSignedRootCar(t, did, func(sr *SignedRoot) []byte {
+		tampered := *sr
+		tampered.Nonce = "something-else"
+		return ed25519.Sign(priv, signedRootPayload(&tampered))
+	})
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 401 {
+		t.Fatalf("expected 401 for a tampered root, got %v", err)
+	}
+
+	// nothing from the rejected car may land in the main store
+	has, err := s.Blockstore.Has(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("rejected update leaked blocks into the blockstore")
+	}
+}
+
+func TestSignedRootWrongKeyRejected(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	_, did := testSigningKey(t)
+
+	other := ed25519.NewKeyFromSeed([]byte("ffffffffffffffffffffffffffffffff"))
+	_, carb := buildSignedRootCar(t, did, func(sr *SignedRoot) []byte {
+		return ed25519.Sign(other, signedRootPayload(sr))
+	})
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 401 {
+		t.Fatalf("expected 401 for a signature from the wrong key, got %v", err)
+	}
+}
+
+func TestParseDidKey(t *testing.T) {
+	_, did := testSigningKey(t)
+	if _, err := parseDidKey(did); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseDidKey("did:web:example.com"); err == nil {
+		t.Fatal("non-did:key dids should be rejected")
+	}
+	if _, err := parseDidKey("did:key:zzzz"); err == nil {
+		t.Fatal("garbage did:key should be rejected")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// storeSize is the incrementally tracked total size of stored blocks.
+func (s *Server) storeSize() int64 {
+	return atomic.LoadInt64(&s.storeBytes)
+}
+
+// touchUser records that a user's data was just served, for LRU
+// eviction decisions.
+func (s *Server) touchUser(id string) {
+	s.servedLk.Lock()
+	defer s.servedLk.Unlock()
+	if s.lastServed == nil {
+		s.lastServed = make(map[string]time.Time)
+	}
+	s.lastServed[id] = time.Now()
+}
+
+// ensureStoreRoom enforces MaxStoreBytes before an update commits. In
+// evict mode it drops least-recently-served users until the update
+// fits, otherwise (or if eviction cant free enough) the update is
+// refused with a 507.
+func (s *Server) ensureStoreRoom(ctx context.Context, need int64) error {
+	if s.MaxStoreBytes == 0 || s.storeSize()+need <= s.MaxStoreBytes {
+		return nil
+	}
+
+	if s.StorePolicy == "evict" {
+		s.evictLRU(ctx, need)
+		if s.storeSize()+need <= s.MaxStoreBytes {
+			return nil
+		}
+	}
+
+	return echo.NewHTTPError(http.StatusInsufficientStorage, "store size cap exceeded")
+}
+
+// evictLRU drops whole users, least recently served first, until the
+// requested room is available.
+func (s *Server) evictLRU(ctx context.Context, need int64) {
+	s.ulk.Lock()
+	names := make([]string, 0, len(s.UserRoots))
+	for name := range s.UserRoots {
+		names = append(names, name)
+	}
+	s.ulk.Unlock()
+
+	s.servedLk.Lock()
+	sort.Slice(names, func(i, j int) bool {
+		return s.lastServed[names[i]].Before(s.lastServed[names[j]])
+	})
+	s.servedLk.Unlock()
+
+	for _, name := range names {
+		if s.storeSize()+need <= s.MaxStoreBytes {
+			return
+		}
+
+		s.ulk.Lock()
+		root := s.UserRoots[name]
+		delete(s.UserRoots, name)
+		delete(s.UserDids, name)
+		s.ulk.Unlock()
+
+		if root.Defined() {
+			freed := s.deleteReachable(ctx, root)
+			atomic.AddInt64(&s.storeBytes, -freed)
+		}
+	}
+}
+
+// deleteReachable removes every block reachable from root, returning
+// the bytes freed. Blocks shared with other users arent tracked in this
+// prototype, eviction assumes disjoint repos.
+func (s *Server) deleteReachable(ctx context.Context, root cid.Cid) int64 {
+	blk, err := s.Blockstore.Get(ctx, root)
+	if err != nil {
+		return 0
+	}
+
+	var links []cid.Cid
+	cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(l cid.Cid) {
+		links = append(links, l)
+	})
+
+	freed := int64(len(blk.RawData()))
+	if err := s.Blockstore.DeleteBlock(ctx, root); err != nil {
+		return 0
+	}
+
+	for _, l := range links {
+		freed += s.deleteReachable(ctx, l)
+	}
+	return freed
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStoreCapReject(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.MaxStoreBytes = 1
+
+	_, carb := testUserCar(t, "alice", 0)
+	err := s.runUpdate(ctx, bytes.NewReader(carb))
+	if err == nil {
+		t.Fatal("expected 507 when the update exceeds the store cap")
+	}
+}
+
+func TestStoreCapEvict(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+	s.StorePolicy = "evict"
+
+	_, carb := testUserCar(t, "alice", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice was served long ago, bob's update needs her room
+	s.servedLk.Lock()
+	s.lastServed = map[string]time.Time{"alice": time.Now().Add(-time.Hour)}
+	s.servedLk.Unlock()
+	s.MaxStoreBytes = s.storeSize() + 16
+
+	_, carb = testUserCar(t, "bob", 0)
+	if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.UserRoots["alice"]; ok {
+		t.Fatal("expected alice to be evicted")
+	}
+	if _, ok := s.UserRoots["bob"]; !ok {
+		t.Fatal("expected bob to be committed")
+	}
+}
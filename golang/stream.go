@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/labstack/echo/v4"
+)
+
+// countingReader tracks how many bytes have passed through it, so the
+// size limit can be enforced without buffering the stream.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// stageCarStream reads an uploaded car in a single pass: the header is
+// validated up front, then every block is hash-checked the moment it
+// arrives and written into a staging store private to this update.
+// Nothing touches the main blockstore until commitCar has validated the
+// staged dag. Compared to the old buffer-then-parse path, peak memory
+// is the staged blocks once rather than the raw car bytes plus a
+// parsed copy of every block.
+func (s *Server) stageCarStream(ctx context.Context, r io.Reader) (cid.Cid, blockstore.Blockstore, int64, error) {
+	// read one byte past the limit so at-the-limit and over are distinct
+	cr := &countingReader{r: io.LimitReader(r, s.maxUpdateBytes()+1)}
+	br := bufio.NewReader(cr)
+
+	hb, err := carutil.LdRead(br)
+	if err != nil {
+		return cid.Undef, nil, 0, echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("unreadable car header: %s", err))
+	}
+	roots, err := validateCarHeaderBytes(hb)
+	if err != nil {
+		return cid.Undef, nil, 0, err
+	}
+	if len(roots) != 1 {
+		return cid.Undef, nil, 0, echo.NewHTTPError(http.StatusBadRequest,
+			"expected exactly one root in car file")
+	}
+	root := roots[0]
+
+	var tracker *orderTracker
+	if s.CarOrderPolicy != CarOrderIgnore {
+		tracker = newOrderTracker()
+	}
+
+	stage := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	for {
+		select {
+		case <-ctx.Done():
+			return cid.Undef, nil, 0, ctx.Err()
+		default:
+		}
+
+		frame, err := carutil.LdRead(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if cr.n > s.maxUpdateBytes() {
+				return cid.Undef, nil, 0, echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("update car exceeds the %d byte limit", s.maxUpdateBytes()))
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return cid.Undef, nil, 0, echo.NewHTTPError(http.StatusBadRequest, "truncated CAR")
+			}
+			return cid.Undef, nil, 0, err
+		}
+
+		n, c, err := cid.CidFromBytes(frame)
+		if err != nil {
+			return cid.Undef, nil, 0, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("car block has an unreadable cid: %s", err))
+		}
+		data := frame[n:]
+
+		if err := verifyBlockCid(c, data); err != nil {
+			return cid.Undef, nil, 0, err
+		}
+
+		if tracker != nil {
+			if err := tracker.observe(c, data); err != nil {
+				if s.CarOrderPolicy == CarOrderWarn {
+					fmt.Println("car block order warning:", err)
+					tracker = nil
+				} else {
+					return cid.Undef, nil, 0, echo.NewHTTPError(http.StatusBadRequest,
+						fmt.Sprintf("car blocks out of order: %s", err))
+				}
+			}
+		}
+
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return cid.Undef, nil, 0, err
+		}
+		if err := stage.Put(ctx, blk); err != nil {
+			return cid.Undef, nil, 0, err
+		}
+	}
+
+	if cr.n > s.maxUpdateBytes() {
+		return cid.Undef, nil, 0, echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("update car exceeds the %d byte limit", s.maxUpdateBytes()))
+	}
+
+	blk, err := stage.Get(ctx, root)
+	if err != nil {
+		return cid.Undef, nil, 0, fmt.Errorf("car does not contain its own root: %w", err)
+	}
+	if err := checkBlockCodec(root, blk.RawData()); err != nil {
+		return cid.Undef, nil, 0, err
+	}
+
+	return root, stage, cr.n, nil
+}
+
+// limitUpdateBody caps a request body at the update byte budget. Reads
+// past the budget fail with the same 413 the streaming path returns, so
+// the buffered update variants (dry-run, async, progress) cant be used
+// to sidestep the limit.
+func (s *Server) limitUpdateBody(r io.Reader) io.Reader {
+	return &budgetReader{r: r, max: s.maxUpdateBytes()}
+}
+
+type budgetReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.n > b.max {
+		return n, echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("update car exceeds the %d byte limit", b.max))
+	}
+	return n, err
+}
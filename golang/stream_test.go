@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	blockservice "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	hamt "github.com/ipfs/go-hamt-ipld/v3"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	merkledag "github.com/ipfs/go-merkledag"
+	car "github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// benchCarWithPosts builds a car whose posts are all distinct blocks,
+// so a block count of n really means n value blocks plus the hamt
+// above them.
+func benchCarWithPosts(b *testing.B, n int) []byte {
+	b.Helper()
+	ctx := context.TODO()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	cst := cbornode.NewCborStore(bs)
+	nd := hamt.NewNode(cst)
+
+	for i := 0; i < n; i++ {
+		val, err := cbornode.WrapObject(map[string]string{"text": fmt.Sprintf("post %d", i)}, mh.SHA2_256, -1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := bs.Put(ctx, val); err != nil {
+			b.Fatal(err)
+		}
+		cc := cbg.CborCid(val.Cid())
+		if err := nd.Set(ctx, fmt.Sprintf("%d", i), &cc); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := nd.Flush(ctx); err != nil {
+		b.Fatal(err)
+	}
+	postsRoot, err := cst.Put(ctx, nd)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	user, err := cbornode.WrapObject(&User{
+		Name:      "alice",
+		NextPost:  int64(n),
+		PostsRoot: postsRoot,
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := bs.Put(ctx, user); err != nil {
+		b.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	ds := merkledag.NewDAGService(blockservice.New(bs, nil))
+	if err := car.WriteCar(ctx, ds, []cid.Cid{user.Cid()}, buf); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func benchServer(b *testing.B) *Server {
+	b.Helper()
+	s, err := NewServer(Config{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+// BenchmarkUpdateStreamed measures the single-pass staging path. Run
+// against BenchmarkUpdateBuffered with -benchmem: the interesting
+// number is B/op, the buffered variant pays for the raw car bytes on
+// top of the parsed blocks.
+func BenchmarkUpdateStreamed(b *testing.B) {
+	carb := benchCarWithPosts(b, 10000)
+	ctx := context.TODO()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(carb)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := benchServer(b)
+		if err := s.runUpdate(ctx, bytes.NewReader(carb)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUpdateBuffered replays the old pipeline: read everything,
+// re-parse the buffer into a temporary blockstore, then commit.
+func BenchmarkUpdateBuffered(b *testing.B) {
+	carb := benchCarWithPosts(b, 10000)
+	ctx := context.TODO()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(carb)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := benchServer(b)
+
+		data := make([]byte, len(carb))
+		copy(data, carb)
+		root, tmpbs, err := parseAndValidateCAR(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := s.commitCar(ctx, tmpbs, root, int64(len(data)), nil, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStreamedUpdateEnforcesOrderPolicy(t *testing.T) {
+	ctx := context.TODO()
+
+	// car.WriteCar emits the root first, a forward reference
+	s := testServer(t)
+	s.CarOrderPolicy = CarOrderReject
+	_, rootFirst := testUserCar(t, "alice", 0)
+	err := s.runUpdate(ctx, bytes.NewReader(rootFirst))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != 400 {
+		t.Fatalf("expected 400 for an out-of-order car, got %v", err)
+	}
+
+	// children-before-parents streams through the same path fine
+	s = testServer(t)
+	s.CarOrderPolicy = CarOrderReject
+	if err := s.runUpdate(ctx, bytes.NewReader(childFirstCar(t))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+func init() {
+	cbornode.RegisterCborType(User{})
+	cbornode.RegisterCborType(SignedRoot{})
+	cbornode.RegisterCborType(Post{})
+	cbornode.RegisterCborType(PostRef{})
+}
+
+// User is the root object of a user's repo. Everything else in the repo
+// is reachable from here.
+type User struct {
+	DID      string
+	Name     string
+	NextPost int64
+	PostsRoot cid.Cid
+}
+
+// SignedRoot wraps a user object with the user's signature over it,
+// bound to a particular server so a signed root cant be replayed
+// elsewhere.
+type SignedRoot struct {
+	User cid.Cid
+	// Audience is the did of the server this root was signed for.
+	Audience string
+	// Nonce is an optional replay nonce chosen by the client.
+	Nonce string
+	// PostCount optionally declares how many entries the posts hamt
+	// holds, zero means undeclared. When set the server verifies it.
+	PostCount int64
+	Sig       []byte
+}
+
+// Post is the leaf record of the posts feed: a bit of text, when it
+// was written, and for replies a pointer back into the thread. Clients
+// have historically shipped free-form maps here, so the shape is only
+// enforced when post validation is switched on.
+type Post struct {
+	Text      string   `refmt:"text"`
+	CreatedAt string   `refmt:"createdAt,omitempty"`
+	Reply     *PostRef `refmt:"reply,omitempty"`
+}
+
+// PostRef points a reply at the post it answers and at the root of the
+// thread it belongs to.
+type PostRef struct {
+	Root   cid.Cid `refmt:"root"`
+	Parent cid.Cid `refmt:"parent"`
+}
+
+// Validate checks the invariants the server relies on when posts are
+// schema-checked: text is mandatory, timestamps are rfc3339 when
+// present, and a reply names both ends of its thread.
+func (p *Post) Validate() error {
+	if p.Text == "" {
+		return fmt.Errorf("post has no text")
+	}
+	if p.CreatedAt != "" {
+		if _, err := time.Parse(time.RFC3339, p.CreatedAt); err != nil {
+			return fmt.Errorf("createdAt %q is not an rfc3339 timestamp", p.CreatedAt)
+		}
+	}
+	if p.Reply != nil && (!p.Reply.Root.Defined() || !p.Reply.Parent.Defined()) {
+		return fmt.Errorf("reply must name both its parent and its thread root")
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	multibase "github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/qri-io/ucan"
+	"github.com/qri-io/ucan/didkey"
+)
+
+func TestMissingProofInChain(t *testing.T) {
+	s := testServer(t)
+
+	pref := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}
+	proofCid, err := pref.Sum([]byte("some delegation token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok := &ucan.Token{
+		Proofs: []ucan.Proof{ucan.Proof(proofCid.String())},
+	}
+
+	err = s.checkProofChain(tok, nil)
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 401 {
+		t.Fatalf("expected 401 for a missing proof, got %v", err)
+	}
+	if !strings.Contains(he.Message.(string), proofCid.String()) {
+		t.Fatalf("error should name the absent proof cid: %v", he.Message)
+	}
+
+	// once the proof is present the chain check passes
+	s.Ucans.Put(proofCid, []byte("some delegation token"))
+	if err := s.checkProofChain(tok, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInlineProofs(t *testing.T) {
+	s := testServer(t)
+
+	proof := "some delegation token"
+	pref := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}
+	proofCid, err := pref.Sum([]byte(proof))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok := &ucan.Token{
+		Proofs: []ucan.Proof{ucan.Proof(proofCid.String())},
+	}
+
+	// without the inline proof the chain is broken
+	if err := s.checkProofChain(tok, nil); err == nil {
+		t.Fatal("expected missing proof error")
+	}
+
+	req := httptest.NewRequest("POST", "/update", nil)
+	req.Header.Set(ProofsHeader, proof)
+	inline, err := s.extractInlineProofs(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.checkProofChain(tok, inline); err != nil {
+		t.Fatalf("inline proof should satisfy the chain: %v", err)
+	}
+
+	// the proof stays scoped to the request, an unverified client must
+	// not be able to fill the shared store
+	if _, ok := s.Ucans.Get(proofCid); ok {
+		t.Fatal("inline proofs must not land in the store before the chain verifies")
+	}
+}
+
+func TestAccountRscExactMatch(t *testing.T) {
+	granted := ucan.Attenuations{
+		{Cap: twitterCaps.Cap("POST"), Rsc: newAccountRsc("twitter", "aaa")},
+	}
+
+	// the old <= comparison let "aaa" authorize any greater account
+	wanted := ucan.Attenuations{
+		{Cap: twitterCaps.Cap("POST"), Rsc: newAccountRsc("twitter", "zzz")},
+	}
+	if granted.Contains(wanted) {
+		t.Fatal("a token for aaa must not authorize zzz")
+	}
+
+	same := ucan.Attenuations{
+		{Cap: twitterCaps.Cap("POST"), Rsc: newAccountRsc("twitter", "aaa")},
+	}
+	if !granted.Contains(same) {
+		t.Fatal("a token should still authorize its own account")
+	}
+
+	// differing schemes never match
+	other := ucan.Attenuations{
+		{Cap: twitterCaps.Cap("POST"), Rsc: newAccountRsc("account", "aaa")},
+	}
+	if granted.Contains(other) {
+		t.Fatal("resource schemes must match exactly")
+	}
+}
+
+func TestAttConstructorRejectsUnknownScheme(t *testing.T) {
+	if _, err := twitterAttConstructor(map[string]interface{}{
+		"cap":   "POST",
+		"bogus": "alice",
+	}); err == nil {
+		t.Fatal("unknown resource schemes should be rejected")
+	}
+
+	att, err := twitterAttConstructor(map[string]interface{}{
+		"cap":     "POST",
+		"twitter": "alice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.Rsc.Type() != "twitter" || att.Rsc.Value() != "alice" {
+		t.Fatalf("unexpected attenuation: %+v", att)
+	}
+}
+
+func TestCapabilityHierarchy(t *testing.T) {
+	rsc := newAccountRsc("twitter", "alice")
+	att := func(cap string) ucan.Attenuations {
+		return ucan.Attenuations{{Cap: twitterCaps.Cap(cap), Rsc: rsc}}
+	}
+
+	// a POST-only token cant delete, but can read
+	if att("POST").Contains(att("DELETE")) {
+		t.Fatal("a POST token must not authorize DELETE")
+	}
+	if !att("POST").Contains(att("READ")) {
+		t.Fatal("POST should imply READ")
+	}
+
+	// ADMIN implies everything below it, but not SUPER_USER
+	for _, c := range []string{"REGISTER", "DELETE", "POST", "READ"} {
+		if !att("ADMIN").Contains(att(c)) {
+			t.Fatalf("ADMIN should imply %s", c)
+		}
+	}
+	if att("ADMIN").Contains(att("SUPER_USER")) {
+		t.Fatal("ADMIN must not imply SUPER_USER")
+	}
+
+	// registration demands its own grant, neither posting nor deleting
+	// is enough, and holding it covers the lesser caps
+	for _, c := range []string{"DELETE", "POST"} {
+		if att(c).Contains(att("REGISTER")) {
+			t.Fatalf("%s must not authorize REGISTER", c)
+		}
+	}
+	if !att("REGISTER").Contains(att("POST")) {
+		t.Fatal("REGISTER should imply POST")
+	}
+}
+
+func TestAccountRscAliceBob(t *testing.T) {
+	// "bob" sorts after "alice", exactly the pair the old lexicographic
+	// comparison got wrong
+	alice := newAccountRsc("twitter", "alice")
+	bob := newAccountRsc("twitter", "bob")
+
+	if alice.Contains(bob) {
+		t.Fatal("a token for alice must not contain bob")
+	}
+	if !alice.Contains(newAccountRsc("twitter", "alice")) {
+		t.Fatal("equal resources must contain each other")
+	}
+	if !bob.Contains(bob) {
+		t.Fatal("equal resources must contain each other")
+	}
+}
+
+// windowToken builds a syntactically valid jwt carrying only the given
+// validity claims. The signature is junk, which is fine for the window
+// check since it runs before verification.
+func windowToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	hdr := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","typ":"JWT"}`))
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(body)
+	return hdr + "." + payload + ".c2ln"
+}
+
+func TestTokenValidityWindow(t *testing.T) {
+	s := testServer(t)
+	now := time.Unix(1_700_000_000, 0)
+	s.Clock = func() time.Time { return now }
+
+	// expired one second ago
+	err := s.checkTokenWindow(windowToken(t, map[string]interface{}{"exp": now.Unix() - 1}))
+	if err == nil || !strings.Contains(err.Error(), "token expired") {
+		t.Fatalf("expected a token expired error, got %v", err)
+	}
+
+	// not valid until one minute from now
+	err = s.checkTokenWindow(windowToken(t, map[string]interface{}{"nbf": now.Unix() + 60}))
+	if err == nil || !strings.Contains(err.Error(), "token not yet valid") {
+		t.Fatalf("expected a not yet valid error, got %v", err)
+	}
+
+	// inside the window, and with no claims at all, the check passes
+	if err := s.checkTokenWindow(windowToken(t, map[string]interface{}{
+		"nbf": now.Unix() - 60, "exp": now.Unix() + 60,
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkTokenWindow(windowToken(t, map[string]interface{}{})); err != nil {
+		t.Fatal(err)
+	}
+
+	// garbage is refused rather than waved through
+	if err := s.checkTokenWindow("not a jwt"); err == nil {
+		t.Fatal("malformed tokens should not pass the window check")
+	}
+}
+
+func TestParseUcanRejectsExpiredToken(t *testing.T) {
+	s := testServer(t)
+	now := time.Unix(1_700_000_000, 0)
+	s.Clock = func() time.Time { return now }
+
+	req := httptest.NewRequest("POST", "/update", nil)
+	req.Header.Set("Authorization", "Bearer "+windowToken(t, map[string]interface{}{"exp": now.Unix() - 1}))
+
+	// the expiry error wins over the (also broken) signature, so the
+	// client learns why the token was refused
+	_, err := s.parseUcan(req)
+	if err == nil || !strings.Contains(err.Error(), "token expired") {
+		t.Fatalf("expected a token expired error, got %v", err)
+	}
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("window errors should map to 401, got %v", err)
+	}
+}
+
+// chainDid derives a didkey identity from a fixed 32-byte seed, for
+// building delegation chains by hand.
+func chainDid(t *testing.T, seed string) didkey.ID {
+	t.Helper()
+	priv := ed25519.NewKeyFromSeed([]byte(seed))
+	enc, err := multibase.Encode(multibase.Base58BTC,
+		append([]byte{0xed, 0x01}, priv.Public().(ed25519.PublicKey)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := didkey.Parse("did:key:" + enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestDelegationChainTwoHop(t *testing.T) {
+	user := chainDid(t, "useruseruseruseruseruseruseruser")
+	app := chainDid(t, "appappappappappappappappappappap")
+
+	postAtt := ucan.Attenuation{
+		Cap: twitterCaps.Cap("POST"),
+		Rsc: newAccountRsc("twitter", "alice"),
+	}
+
+	// user grants POST on their account to the client app, which then
+	// issues the token the server actually sees
+	grant := &ucan.Token{
+		Issuer:       user,
+		Audience:     app,
+		Attenuations: ucan.Attenuations{postAtt},
+	}
+	leaf := &ucan.Token{
+		Issuer:       app,
+		Attenuations: ucan.Attenuations{postAtt},
+		Proofs:       []ucan.Proof{ucan.Proof("grant")},
+	}
+
+	resolve := func(prf ucan.Proof) (*ucan.Token, error) {
+		if string(prf) == "grant" {
+			return grant, nil
+		}
+		return nil, fmt.Errorf("unknown proof %q", prf)
+	}
+
+	rootDid, err := walkDelegationChain(leaf, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootDid != user.String() {
+		t.Fatalf("chain root %s, want the user %s", rootDid, user)
+	}
+
+	// a token with no proofs is its own root
+	rootDid, err = walkDelegationChain(grant, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootDid != user.String() {
+		t.Fatalf("proofless token root %s, want its issuer %s", rootDid, user)
+	}
+}
+
+func TestDelegationChainRejectsEscalation(t *testing.T) {
+	user := chainDid(t, "useruseruseruseruseruseruseruser")
+	app := chainDid(t, "appappappappappappappappappappap")
+	other := chainDid(t, "otherotherotherotherotherotherot")
+
+	grant := &ucan.Token{
+		Issuer:   user,
+		Audience: app,
+		Attenuations: ucan.Attenuations{{
+			Cap: twitterCaps.Cap("POST"),
+			Rsc: newAccountRsc("twitter", "alice"),
+		}},
+	}
+	resolve := func(prf ucan.Proof) (*ucan.Token, error) {
+		return grant, nil
+	}
+
+	// the app was granted POST but mints itself REGISTER
+	escalated := &ucan.Token{
+		Issuer: app,
+		Attenuations: ucan.Attenuations{{
+			Cap: twitterCaps.Cap("REGISTER"),
+			Rsc: newAccountRsc("twitter", "alice"),
+		}},
+		Proofs: []ucan.Proof{ucan.Proof("grant")},
+	}
+	_, err := walkDelegationChain(escalated, resolve)
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 401 {
+		t.Fatalf("expected 401 for an escalated delegation, got %v", err)
+	}
+	if !strings.Contains(he.Message.(string), "exceeds") {
+		t.Fatalf("error should say the grant was exceeded: %v", he.Message)
+	}
+
+	// same capability but aimed at a different account is just as bad
+	wrongRsc := &ucan.Token{
+		Issuer: app,
+		Attenuations: ucan.Attenuations{{
+			Cap: twitterCaps.Cap("POST"),
+			Rsc: newAccountRsc("twitter", "bob"),
+		}},
+		Proofs: []ucan.Proof{ucan.Proof("grant")},
+	}
+	if _, err := walkDelegationChain(wrongRsc, resolve); err == nil {
+		t.Fatal("a delegation for another account should be rejected")
+	}
+
+	// a token presented by someone the grant wasnt delegated to
+	stolen := &ucan.Token{
+		Issuer:       other,
+		Attenuations: grant.Attenuations,
+		Proofs:       []ucan.Proof{ucan.Proof("grant")},
+	}
+	_, err = walkDelegationChain(stolen, resolve)
+	he, ok = err.(*echo.HTTPError)
+	if !ok || he.Code != 401 {
+		t.Fatalf("expected 401 for a stolen delegation, got %v", err)
+	}
+}
+
+func TestDelegationChainDepthLimit(t *testing.T) {
+	user := chainDid(t, "useruseruseruseruseruseruseruser")
+
+	att := ucan.Attenuations{{
+		Cap: twitterCaps.Cap("POST"),
+		Rsc: newAccountRsc("twitter", "alice"),
+	}}
+
+	// a self-referential proof never reaches a root
+	loop := &ucan.Token{
+		Issuer:       user,
+		Audience:     user,
+		Attenuations: att,
+		Proofs:       []ucan.Proof{ucan.Proof("loop")},
+	}
+	resolve := func(prf ucan.Proof) (*ucan.Token, error) {
+		return loop, nil
+	}
+
+	_, err := walkDelegationChain(loop, resolve)
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != 401 || !strings.Contains(he.Message.(string), "too deep") {
+		t.Fatalf("expected a chain depth error, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// userEntry is one row of the /users listing.
+type userEntry struct {
+	Name string `json:"name"`
+	Did  string `json:"did"`
+	Root string `json:"root"`
+}
+
+// handleListUsers enumerates registered users as pages sorted by name.
+// The cursor is just the last name of the previous page, so the order
+// stays stable while clients walk it even as registrations come in. A
+// page carries a cursor whenever it is full; following it past the end
+// yields an empty page.
+func (s *Server) handleListUsers(c echo.Context) error {
+	limit, cursor, err := s.parsePage(c)
+	if err != nil {
+		return err
+	}
+
+	s.ulk.Lock()
+	all := make([]userEntry, 0, len(s.UserRoots))
+	for name, root := range s.UserRoots {
+		all = append(all, userEntry{
+			Name: name,
+			Did:  s.UserDids[name],
+			Root: s.renderCid(root),
+		})
+	}
+	s.ulk.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+
+	users := []userEntry{}
+	for _, u := range all {
+		if cursor != "" && u.Name <= cursor {
+			continue
+		}
+		if len(users) == limit {
+			break
+		}
+		users = append(users, u)
+	}
+
+	out := map[string]interface{}{"users": users}
+	if len(users) == limit && limit > 0 {
+		out["cursor"] = users[len(users)-1].Name
+	}
+	return c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+)
+
+type usersPage struct {
+	Users  []userEntry `json:"users"`
+	Cursor string      `json:"cursor"`
+}
+
+func listUsersPage(t *testing.T, s *Server, query string) usersPage {
+	t.Helper()
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/users"+query, nil)
+	if err := s.handleListUsers(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var page usersPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestListUsersPagination(t *testing.T) {
+	s := testServer(t)
+
+	pref := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}
+	names := []string{"alice", "bob", "carol", "dave", "erin"}
+	for _, name := range names {
+		root, err := pref.Sum([]byte(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.UserRoots[name] = root
+		s.UserDids[name] = fmt.Sprintf("did:key:z%s", name)
+	}
+
+	// first page: the two lowest names, with a cursor pointing onward
+	first := listUsersPage(t, s, "?limit=2")
+	if len(first.Users) != 2 || first.Users[0].Name != "alice" || first.Users[1].Name != "bob" {
+		t.Fatalf("unexpected first page: %+v", first.Users)
+	}
+	if first.Users[0].Did != "did:key:zalice" || first.Users[0].Root == "" {
+		t.Fatalf("entries should carry did and root: %+v", first.Users[0])
+	}
+	if first.Cursor != "bob" {
+		t.Fatalf("cursor should be the last returned name, got %q", first.Cursor)
+	}
+
+	// continuation picks up after the cursor
+	second := listUsersPage(t, s, "?limit=2&cursor="+first.Cursor)
+	if len(second.Users) != 2 || second.Users[0].Name != "carol" || second.Users[1].Name != "dave" {
+		t.Fatalf("unexpected second page: %+v", second.Users)
+	}
+
+	// the short last page still carries a cursor only when full
+	third := listUsersPage(t, s, "?limit=2&cursor="+second.Cursor)
+	if len(third.Users) != 1 || third.Users[0].Name != "erin" {
+		t.Fatalf("unexpected third page: %+v", third.Users)
+	}
+	if third.Cursor != "" {
+		t.Fatalf("a short page should not carry a cursor, got %q", third.Cursor)
+	}
+
+	// walking past the end yields an empty page
+	empty := listUsersPage(t, s, "?limit=2&cursor=erin")
+	if len(empty.Users) != 0 || empty.Cursor != "" {
+		t.Fatalf("expected an empty final page, got %+v", empty)
+	}
+}
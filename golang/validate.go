@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/labstack/echo/v4"
+)
+
+// validationError is one failed check from a dry-run validation pass.
+// Code is stable and machine-matchable, Cid names the offending block
+// where there is one.
+type validationError struct {
+	Code    string `json:"code"`
+	Cid     string `json:"cid,omitempty"`
+	Message string `json:"message"`
+}
+
+type validationReport struct {
+	Valid  bool              `json:"valid"`
+	Errors []validationError `json:"errors,omitempty"`
+}
+
+// validateAll runs every update check over a buffered car and collects
+// all the failures, rather than stopping at the first like the commit
+// path does. Clients use this to fix a broken upload in one round trip.
+func (s *Server) validateAll(ctx context.Context, tmpbs blockstore.Blockstore, root cid.Cid) []validationError {
+	var out []validationError
+	add := func(code string, c cid.Cid, err error) {
+		ve := validationError{Code: code, Message: err.Error()}
+		if c.Defined() {
+			ve.Cid = s.renderCid(c)
+		}
+		out = append(out, ve)
+	}
+
+	u, sr, err := s.decodeUserRoot(ctx, tmpbs, root)
+	if err != nil {
+		// without a decodable root none of the structural checks can run
+		add("bad_root", root, err)
+		return out
+	}
+
+	if sr != nil && sr.PostCount > 0 {
+		n, err := s.countHamtEntries(ctx, tmpbs, u.PostsRoot)
+		if err != nil {
+			add("post_count_uncountable", u.PostsRoot, err)
+		} else if n != sr.PostCount {
+			add("post_count_mismatch", u.PostsRoot,
+				echo.NewHTTPError(http.StatusBadRequest, "declared post count does not match the tree"))
+		}
+	}
+
+	if err := s.checkCollectionRoot(ctx, tmpbs, u.PostsRoot, "posts"); err != nil {
+		add("bad_collection_root", u.PostsRoot, err)
+	}
+
+	if err := s.validateCollectionRecords(ctx, tmpbs, u.PostsRoot, "posts"); err != nil {
+		add("bad_record", cid.Undef, err)
+	}
+
+	if err := s.ensureGraphWalkability(ctx, u, tmpbs); err != nil {
+		add("unwalkable", u.PostsRoot, err)
+	}
+
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	datastore "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestValidateAllCollectsEveryFailure(t *testing.T) {
+	ctx := context.TODO()
+	s := testServer(t)
+
+	// a posts root that is in neither the upload nor the server store
+	dangling, err := cbornode.WrapObject(map[string]string{"nowhere": "tobefound"}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpbs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	user, err := cbornode.WrapObject(&User{
+		Name:      "alice",
+		PostsRoot: dangling.Cid(),
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpbs.Put(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// declare a post count the (missing) tree cant possibly back up
+	signed, err := cbornode.WrapObject(&SignedRoot{
+		User:      user.Cid(),
+		Audience:  TwitterDid,
+		PostCount: 5,
+		Sig:       []byte{0xde, 0xad},
+	}, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpbs.Put(ctx, signed); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := s.validateAll(ctx, tmpbs, signed.Cid())
+	if len(errs) < 2 {
+		t.Fatalf("expected multiple validation errors, got %d: %v", len(errs), errs)
+	}
+
+	codes := make(map[string]bool)
+	for _, ve := range errs {
+		codes[ve.Code] = true
+	}
+	if !codes["post_count_uncountable"] {
+		t.Fatalf("expected a post count error, got codes %v", codes)
+	}
+	if !codes["bad_collection_root"] {
+		t.Fatalf("expected a collection root error, got codes %v", codes)
+	}
+}